@@ -0,0 +1,685 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"transitrhythm.com/gtfs-parse/timetable"
+)
+
+// Exit status of the gtfs-parse commands
+const (
+	exitOK      = 0 // the command completed
+	exitFailure = 1 // the feed could not be loaded or the report could not be produced
+	exitUsage   = 2 // the command line was invalid
+)
+
+// command - a gtfs-parse subcommand
+type command struct {
+	name        string
+	description string
+	run         func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"stop-timetable", "timetable of a stop for a week or date range", stopTimetableCommand},
+		{"route-timetable", "public timetable of a route for a date, timepoints by trips", routeTimetableCommand},
+		{"block-week", "schedule of a block for a week or date range", blockWeekCommand},
+		{"block-month", "calendar of all blocks for this month or a date range", blockMonthCommand},
+		{"block-check", "overlaps, short layovers & terminal mismatches of the blocks", blockCheckCommand},
+		{"deadheads", "deadhead trips for a week", deadheadsCommand},
+		{"stats", "revenue & deadhead hours and km by route, block, service & date", statsCommand},
+		{"fleet", "peak vehicle requirement by day, route & garage for a week or date range", fleetCommand},
+		{"headways", "headways by stop, route & time band and the frequent network", headwaysCommand},
+		{"serve", "serve the feed over HTTP", serveCommand},
+		{"validate", "parse the feed and report any errors", validateCommand},
+		{"info", "summarise the feed", infoCommand},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: gtfs-parse <command> [flags] <GTFS zip>\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", c.name, c.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'gtfs-parse <command> -h' for the flags of a command.\n")
+}
+
+// runCommand - runs the subcommand named by the first argument and returns the process exit status
+func runCommand(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return exitUsage
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage()
+		return exitOK
+	}
+	fmt.Fprintf(os.Stderr, "gtfs-parse: unknown command %q\n", args[0])
+	usage()
+	return exitUsage
+}
+
+// options - the flags shared by every command
+type options struct {
+	outputDir  string
+	format     string
+	start      string
+	end        string
+	agencyID   string
+	lang       string
+	logFile    string
+	nextDay    bool
+	startTime  string
+	templates  string
+	paper      string
+	config     string
+	addrs      string
+	minLayover string
+	realtime   realtimeConfig
+	deadheads  deadheadConfig
+	garage     *timetable.Garage
+	headways   headwayConfig
+
+	formats     []string
+	zipFile     string
+	startDate   gtfs.Date
+	endDate     gtfs.Date
+	dateRange   bool
+	weekEnding  gtfs.Date
+	paperSize   timetable.PaperSize
+	startOfDay  gtfs.Time
+	log         *os.File
+	optionalZip bool // the GTFS zip argument may be left out
+	settings    *config
+}
+
+// reportFormats - the output formats supported by the report commands, unless a command lists its own
+var reportFormats = []string{"csv"}
+
+func newFlagSet(name string, arguments string, opts *options, formats ...string) *flag.FlagSet {
+	if len(formats) == 0 {
+		formats = reportFormats
+	}
+	opts.formats = formats
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: gtfs-parse %s [flags] %s\n\nFlags:\n", name, arguments)
+		flags.PrintDefaults()
+	}
+	startOfDay := timetable.GTFSSeconds(int(timetable.DefaultStartOfDay / time.Second))
+	flags.StringVar(&opts.outputDir, "o", ".", "output `directory` for the generated reports")
+	flags.StringVar(&opts.format, "format", "csv", "report format: "+strings.Join(formats, ", "))
+	flags.StringVar(&opts.start, "start", "", "first `date` (YYYY-MM-DD) of the report range")
+	flags.StringVar(&opts.end, "end", "", "last `date` (YYYY-MM-DD) of the report range, or the week ending date of a weekly report")
+	flags.StringVar(&opts.agencyID, "agency", "", "restrict the reports to this agency_id")
+	flags.StringVar(&opts.lang, "lang", "", "`language` of the weekday names (en, es, fr, pt) instead of the agency_lang")
+	flags.BoolVar(&opts.nextDay, "next-day", false, "show times past 24:00 under the next calendar day instead of their service day")
+	flags.StringVar(&opts.startTime, "start-of-day", startOfDay, "`time` (HH:MM:SS) at which one operating day ends and the next begins")
+	flags.StringVar(&opts.templates, "templates", timetable.DefaultTemplateDir, "`directory` of the HTML page templates")
+	flags.StringVar(&opts.paper, "paper", timetable.A4.Name, "page `size` of the PDF reports: A4 or Letter")
+	flags.StringVar(&opts.logFile, "log", "GTFS-Parse.log", "log `file`, - for standard error")
+	flags.StringVar(&opts.config, "config", os.Getenv(envPrefix+"CONFIG"), "YAML or JSON config `file`, overridden by "+envPrefix+"* variables and the flags")
+	return flags
+}
+
+// parse - parses the command line into the options, leaving a single GTFS zip file argument. The command only
+// proceeds when ok is true, otherwise it exits with the returned status
+func (o *options) parse(flags *flag.FlagSet, args []string) (ok bool, status int) {
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return false, exitOK
+		}
+		return false, exitUsage
+	}
+	if flags.NArg() > 1 || (flags.NArg() == 0 && !o.optionalZip) {
+		return false, o.usageError(flags, "expected a single GTFS zip file argument")
+	}
+	o.zipFile = flags.Arg(0)
+
+	var err error
+	if o.settings, err = readConfig(o.config); err != nil {
+		fmt.Fprintf(flags.Output(), "gtfs-parse %s: %v\n", flags.Name(), err)
+		return false, exitUsage
+	}
+	o.applyConfig(flags)
+	if status := o.parseDeadheads(flags); status != exitOK {
+		return false, status
+	}
+
+	formatOK := false
+	for _, format := range o.formats {
+		formatOK = formatOK || o.format == format
+	}
+	if !formatOK {
+		return false, o.usageError(flags, fmt.Sprintf("unsupported -format %q", o.format))
+	}
+
+	if o.end != "" {
+		if o.endDate, err = timetable.ParseDate(o.end); err != nil {
+			return false, o.usageError(flags, "-end: "+err.Error())
+		}
+		o.weekEnding = o.endDate
+	}
+	if o.start != "" {
+		if o.startDate, err = timetable.ParseDate(o.start); err != nil {
+			return false, o.usageError(flags, "-start: "+err.Error())
+		}
+		if o.end == "" {
+			return false, o.usageError(flags, "-start requires -end")
+		}
+		if timetable.DateBefore(o.endDate, o.startDate) {
+			return false, o.usageError(flags, fmt.Sprintf("-end %s is before -start %s", o.end, o.start))
+		}
+		o.dateRange = true
+	}
+	if o.end != "" && !o.dateRange && !timetable.IsWeekEnding(o.endDate) {
+		return false, o.usageError(flags, fmt.Sprintf("-end %s is not a Sunday, the week ending date of a weekly report", o.end))
+	}
+	if o.paperSize, err = timetable.ParsePaperSize(o.paper); err != nil {
+		return false, o.usageError(flags, "-paper: "+err.Error())
+	}
+	if o.startOfDay, err = timetable.ParseTime(o.startTime); err != nil {
+		return false, o.usageError(flags, "-start-of-day: "+err.Error())
+	}
+	return true, exitOK
+}
+
+// applyConfig - takes the settings of the config file & environment for the flags left out of the command line
+func (o *options) applyConfig(flags *flag.FlagSet) {
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	settings := []struct {
+		flag   string
+		value  string
+		option *string
+	}{
+		{"o", o.settings.OutputDir, &o.outputDir},
+		{"log", o.settings.Log, &o.logFile},
+		{"templates", o.settings.Templates, &o.templates},
+		{"paper", o.settings.Paper, &o.paper},
+		{"start-of-day", o.settings.StartOfDay, &o.startTime},
+		{"lang", o.settings.Lang, &o.lang},
+		{"addr", strings.Join(o.settings.HTTP.Addrs, ","), &o.addrs},
+		{"trip-updates", o.settings.Realtime.TripUpdates, &o.realtime.TripUpdates},
+		{"vehicle-positions", o.settings.Realtime.VehiclePositions, &o.realtime.VehiclePositions},
+		{"realtime-interval", o.settings.Realtime.Interval, &o.realtime.Interval},
+		{"min-layover", o.settings.MinLayover, &o.minLayover},
+		{"garage", o.settings.Deadheads.Garage, &o.deadheads.Garage},
+		{"garage-name", o.settings.Deadheads.GarageName, &o.deadheads.GarageName},
+		{"estimate", o.settings.Deadheads.Estimate, &o.deadheads.Estimate},
+		{"bands", o.settings.Headways.Bands, &o.headways.Bands},
+		{"frequent", o.settings.Headways.Frequent, &o.headways.Frequent},
+		{"window", o.settings.Headways.Window, &o.headways.Window},
+	}
+	for _, setting := range settings {
+		if !set[setting.flag] && setting.value != "" {
+			*setting.option = setting.value
+		}
+	}
+	if !set["capacity"] && o.settings.Realtime.PassengerCapacity != 0 {
+		o.realtime.PassengerCapacity = o.settings.Realtime.PassengerCapacity
+	}
+	if !set["speed"] && o.settings.Deadheads.Speed != 0 {
+		o.deadheads.Speed = o.settings.Deadheads.Speed
+	}
+	for kind, pattern := range o.settings.OutputNames {
+		outputNames[kind] = pattern
+	}
+}
+
+// deadheadFlags - adds the flags of the garage & the deadhead estimates
+func (o *options) deadheadFlags(flags *flag.FlagSet) {
+	flags.StringVar(&o.deadheads.Garage, "garage", "", "`lat,lon` of the garage the blocks pull out from & pull in to")
+	flags.StringVar(&o.deadheads.GarageName, "garage-name", "Garage", "`name` of the garage")
+	flags.Float64Var(&o.deadheads.Speed, "speed", timetable.DefaultDeadheadSpeed, "average deadhead `speed` in km/h")
+	flags.StringVar(&o.deadheads.Estimate, "estimate", timetable.StraightLineEstimate, "deadhead `estimate` between stops: "+strings.Join(timetable.EstimateMethods(), ", "))
+}
+
+// parseDeadheads - checks the garage & deadhead settings of the flags or the config file
+func (o *options) parseDeadheads(flags *flag.FlagSet) int {
+	if o.deadheads.Garage != "" {
+		name := o.deadheads.GarageName
+		if name == "" {
+			name = "Garage"
+		}
+		var err error
+		if o.garage, err = timetable.ParseGarage(name, o.deadheads.Garage); err != nil {
+			return o.usageError(flags, "-garage: "+err.Error())
+		}
+	}
+	if o.deadheads.Speed < 0 {
+		return o.usageError(flags, fmt.Sprintf("-speed: invalid speed %g", o.deadheads.Speed))
+	}
+	if o.deadheads.Estimate != "" && !contains(timetable.EstimateMethods(), o.deadheads.Estimate) {
+		return o.usageError(flags, fmt.Sprintf("-estimate: unknown estimate %q", o.deadheads.Estimate))
+	}
+	return exitOK
+}
+
+func (o *options) usageError(flags *flag.FlagSet, message string) int {
+	fmt.Fprintf(flags.Output(), "gtfs-parse %s: %s\n", flags.Name(), message)
+	flags.Usage()
+	return exitUsage
+}
+
+// open - opens the log and creates the output directory
+func (o *options) open() int {
+	var err error
+	if o.log, err = createLogFile(o.logFile); err != nil {
+		fmt.Fprintln(os.Stderr, "gtfs-parse:", err)
+		return exitFailure
+	}
+	if err = os.MkdirAll(o.outputDir, 0755); err != nil {
+		return o.fail(err)
+	}
+	return exitOK
+}
+
+// loadFeed - parses the GTFS zip file into a Scheduler configured by the options
+func (o *options) loadFeed(zipFile string) (*timetable.Scheduler, error) {
+	scheduler, err := timetable.Load(zipFile)
+	if err != nil {
+		return nil, fmt.Errorf("feed parse failure: %s: %v", zipFile, err)
+	}
+	feed := scheduler.Feed
+	log.Printf("Done, parsed %d agencies, %d stops, %d routes, %d trips, %d fare attributes\n\n",
+		len(feed.Agencies), len(feed.Stops), len(feed.Routes), len(feed.Trips), len(feed.FareAttributes))
+	if o.agencyID != "" && feed.Agencies[o.agencyID] == nil {
+		return nil, fmt.Errorf("no agency with agency_id %q", o.agencyID)
+	}
+	scheduler.OutputDir = o.outputDir
+	scheduler.AgencyID = o.agencyID
+	scheduler.Lang = o.lang
+	scheduler.NextDayDisplay = o.nextDay
+	scheduler.StartOfDay = o.startOfDay
+	scheduler.TemplateDir = o.templates
+	scheduler.Paper = o.paperSize
+	scheduler.Garage = o.garage
+	scheduler.DeadheadSpeed = o.deadheads.Speed
+	scheduler.DeadheadEstimate = o.deadheads.Estimate
+	return scheduler, nil
+}
+
+// load - opens the log and output directory, then parses the feed into a Scheduler configured by the options
+func (o *options) load() (*timetable.Scheduler, int) {
+	if status := o.open(); status != exitOK {
+		return nil, status
+	}
+	scheduler, err := o.loadFeed(o.zipFile)
+	if err != nil {
+		return nil, o.fail(err)
+	}
+	if o.end == "" {
+		o.weekEnding = timetable.ThisSunday(scheduler.Location())
+	}
+	return scheduler, exitOK
+}
+
+// fail - reports the error to both the log and standard error
+func (o *options) fail(err error) int {
+	log.Println(err)
+	fmt.Fprintln(os.Stderr, "gtfs-parse:", err)
+	return exitFailure
+}
+
+// finish - reports the outcome of a command and closes the log
+func (o *options) finish(err error) (status int) {
+	status = exitOK
+	if err != nil {
+		status = o.fail(err)
+	}
+	if o.log != nil {
+		o.log.Close()
+	}
+	return status
+}
+
+func stopTimetableCommand(args []string) int {
+	var opts options
+	flags := newFlagSet("stop-timetable", "-stop <StopCode>[,<StopCode>...] | -station <StopID> <GTFS zip>", &opts, "csv", "html", "pdf")
+	stopCodes := flags.String("stop", "", "comma separated stop_codes of the timetable `stops`")
+	stationID := flags.String("station", "", "stop_id of the parent `station` whose platforms make up the timetable")
+	if ok, status := opts.parse(flags, args); !ok {
+		return status
+	}
+	if (*stopCodes == "") == (*stationID == "") {
+		return opts.usageError(flags, "either -stop or -station is required")
+	}
+	scheduler, status := opts.load()
+	if scheduler == nil {
+		return status
+	}
+	stops, err := findStops(scheduler, *stopCodes, *stationID)
+	if err != nil {
+		return opts.finish(err)
+	}
+	name := *stationID
+	if name == "" {
+		name = strings.Replace(*stopCodes, ",", "+", -1)
+	}
+	if opts.dateRange {
+		return opts.finish(processStopsRange(scheduler, stops, name, opts.startDate, opts.endDate, opts.format))
+	}
+	return opts.finish(processStops(scheduler, stops, name, opts.weekEnding, opts.format))
+}
+
+func routeTimetableCommand(args []string) int {
+	var opts options
+	flags := newFlagSet("route-timetable", "-route <RouteID> <GTFS zip>", &opts, "csv", "html")
+	routeID := flags.String("route", "", "route_id of the timetable `route` (required)")
+	date := flags.String("date", "", "service `date` (YYYY-MM-DD) of the timetable, today by default")
+	if ok, status := opts.parse(flags, args); !ok {
+		return status
+	}
+	if *routeID == "" {
+		return opts.usageError(flags, "-route is required")
+	}
+	var serviceDate gtfs.Date
+	var err error
+	if *date != "" {
+		if serviceDate, err = timetable.ParseDate(*date); err != nil {
+			return opts.usageError(flags, "-date: "+err.Error())
+		}
+	}
+	scheduler, status := opts.load()
+	if scheduler == nil {
+		return status
+	}
+	if *date == "" {
+		serviceDate = timetable.Today(scheduler.Location())
+	}
+	return opts.finish(processRouteTimetables(scheduler, *routeID, serviceDate, opts.format))
+}
+
+func blockWeekCommand(args []string) int {
+	var opts options
+	flags := newFlagSet("block-week", "-block <BlockID> <GTFS zip>", &opts, "csv", "html", "pdf")
+	blockID := flags.String("block", "", "block_id of the `block` (required)")
+	if ok, status := opts.parse(flags, args); !ok {
+		return status
+	}
+	if *blockID == "" {
+		return opts.usageError(flags, "-block is required")
+	}
+	scheduler, status := opts.load()
+	if scheduler == nil {
+		return status
+	}
+	if opts.dateRange {
+		return opts.finish(processBlockRange(scheduler, *blockID, opts.startDate, opts.endDate, opts.format))
+	}
+	return opts.finish(processBlockWeek(scheduler, *blockID, opts.weekEnding, opts.format))
+}
+
+func blockMonthCommand(args []string) int {
+	var opts options
+	flags := newFlagSet("block-month", "<GTFS zip>", &opts, "csv", "pdf")
+	if ok, status := opts.parse(flags, args); !ok {
+		return status
+	}
+	scheduler, status := opts.load()
+	if scheduler == nil {
+		return status
+	}
+	if opts.dateRange {
+		return opts.finish(processBlockCalendarRange(scheduler, opts.startDate, opts.endDate, opts.format))
+	}
+	return opts.finish(processBlockMonth(scheduler, opts.format))
+}
+
+func blockCheckCommand(args []string) int {
+	var opts options
+	flags := newFlagSet("block-check", "[-block <BlockID>] <GTFS zip>", &opts, "csv", "json")
+	blockID := flags.String("block", "", "block_id of the `block`, every block if left out")
+	flags.StringVar(&opts.minLayover, "min-layover", timetable.DefaultMinLayover.String(), "shortest `duration` between two trips of a block that is not reported")
+	if ok, status := opts.parse(flags, args); !ok {
+		return status
+	}
+	minLayover, err := time.ParseDuration(opts.minLayover)
+	if err != nil || minLayover < 0 {
+		return opts.usageError(flags, fmt.Sprintf("-min-layover: invalid duration %q", opts.minLayover))
+	}
+	scheduler, status := opts.load()
+	if scheduler == nil {
+		return status
+	}
+	if *blockID != "" {
+		if err := findBlock(scheduler, *blockID); err != nil {
+			return opts.finish(err)
+		}
+	}
+	if opts.dateRange {
+		return opts.finish(processBlockCheckRange(scheduler, *blockID, opts.startDate, opts.endDate, minLayover, opts.format))
+	}
+	return opts.finish(processBlockCheck(scheduler, *blockID, opts.weekEnding, minLayover, opts.format))
+}
+
+func deadheadsCommand(args []string) int {
+	var opts options
+	flags := newFlagSet("deadheads", "<GTFS zip>", &opts, "csv", "pdf")
+	opts.deadheadFlags(flags)
+	if ok, status := opts.parse(flags, args); !ok {
+		return status
+	}
+	if opts.dateRange {
+		return opts.usageError(flags, "-start is not supported, deadheads are reported for the week ending -end")
+	}
+	scheduler, status := opts.load()
+	if scheduler == nil {
+		return status
+	}
+	return opts.finish(processDeadheads(scheduler, opts.weekEnding, opts.format))
+}
+
+func statsCommand(args []string) int {
+	var opts options
+	flags := newFlagSet("stats", "<GTFS zip>", &opts, "csv", "json")
+	opts.deadheadFlags(flags)
+	if ok, status := opts.parse(flags, args); !ok {
+		return status
+	}
+	scheduler, status := opts.load()
+	if scheduler == nil {
+		return status
+	}
+	if opts.dateRange {
+		return opts.finish(processStatsRange(scheduler, opts.startDate, opts.endDate, opts.format))
+	}
+	return opts.finish(processStatsWeek(scheduler, opts.weekEnding, opts.format))
+}
+
+func headwaysCommand(args []string) int {
+	var opts options
+	flags := newFlagSet("headways", "[-stop <StopCode>[,<StopCode>...] | -station <StopID>] <GTFS zip>", &opts, "csv", "json")
+	stopCodes := flags.String("stop", "", "comma separated stop_codes of the `stops`, all stops by default")
+	stationID := flags.String("station", "", "stop_id of the parent `station` whose platforms are reported")
+	flags.StringVar(&opts.headways.Bands, "bands", timetable.DefaultHeadwayBands, "comma separated HH:MM-HH:MM time `bands`")
+	flags.StringVar(&opts.headways.Frequent, "frequent", timetable.DefaultFrequentHeadway.String(), "longest `headway` of the frequent network")
+	flags.StringVar(&opts.headways.Window, "window", timetable.DefaultFrequentWindow, "HH:MM-HH:MM `window` through which the frequent network keeps its headway")
+	if ok, status := opts.parse(flags, args); !ok {
+		return status
+	}
+	if *stopCodes != "" && *stationID != "" {
+		return opts.usageError(flags, "-stop and -station cannot be combined")
+	}
+	bands, err := timetable.ParseTimeBands(opts.headways.Bands)
+	if err != nil {
+		return opts.usageError(flags, "-bands: "+err.Error())
+	}
+	frequent, err := time.ParseDuration(opts.headways.Frequent)
+	if err != nil || frequent <= 0 {
+		return opts.usageError(flags, fmt.Sprintf("-frequent: invalid duration %q", opts.headways.Frequent))
+	}
+	window, err := timetable.ParseTimeBand(opts.headways.Window)
+	if err != nil {
+		return opts.usageError(flags, "-window: "+err.Error())
+	}
+	scheduler, status := opts.load()
+	if scheduler == nil {
+		return status
+	}
+	stops, name := scheduler.AllStops(), "All"
+	if *stopCodes != "" || *stationID != "" {
+		if stops, err = findStops(scheduler, *stopCodes, *stationID); err != nil {
+			return opts.finish(err)
+		}
+		name = *stationID
+		if name == "" {
+			name = strings.Replace(*stopCodes, ",", "+", -1)
+		}
+	}
+	if opts.dateRange {
+		return opts.finish(processHeadwaysRange(scheduler, stops, name, opts.startDate, opts.endDate, bands, frequent, window, opts.format))
+	}
+	return opts.finish(processHeadwaysWeek(scheduler, stops, name, opts.weekEnding, bands, frequent, window, opts.format))
+}
+
+func fleetCommand(args []string) int {
+	var opts options
+	flags := newFlagSet("fleet", "<GTFS zip>", &opts, "csv", "json")
+	opts.deadheadFlags(flags)
+	interval := flags.Duration("interval", timetable.DefaultFleetInterval, "width of the time of day `bands` of the histogram")
+	if ok, status := opts.parse(flags, args); !ok {
+		return status
+	}
+	if *interval < time.Minute || *interval > 24*time.Hour || *interval%time.Minute != 0 {
+		return opts.usageError(flags, fmt.Sprintf("-interval: %s is not a whole number of minutes up to 24h", *interval))
+	}
+	scheduler, status := opts.load()
+	if scheduler == nil {
+		return status
+	}
+	if opts.dateRange {
+		return opts.finish(processFleetRange(scheduler, opts.startDate, opts.endDate, *interval, opts.format))
+	}
+	return opts.finish(processFleetWeek(scheduler, opts.weekEnding, *interval, opts.format))
+}
+
+func serveCommand(args []string) int {
+	opts := options{optionalZip: true}
+	flags := newFlagSet("serve", "[<GTFS zip>]", &opts)
+	flags.StringVar(&opts.addrs, "addr", "localhost:8081", "comma separated HTTP listen `addresses`")
+	feeds := flags.String("feeds", "", "JSON feed registry `file` mapping authority & agency keys to GTFS zip files")
+	flags.StringVar(&opts.realtime.TripUpdates, "trip-updates", "", "GTFS-RT TripUpdates `file or URL` polled for schedule adherence")
+	flags.StringVar(&opts.realtime.VehiclePositions, "vehicle-positions", "", "GTFS-RT VehiclePositions `file or URL` polled for vehicle locations & loads")
+	flags.StringVar(&opts.realtime.Interval, "realtime-interval", defaultRealtimeInterval.String(), "`interval` between reads of the GTFS-RT feeds")
+	flags.IntVar(&opts.realtime.PassengerCapacity, "capacity", 0, "passenger `capacity` of a vehicle, from which the loads are estimated")
+	opts.deadheadFlags(flags)
+	if ok, status := opts.parse(flags, args); !ok {
+		return status
+	}
+	if opts.realtime.PassengerCapacity < 0 {
+		return opts.usageError(flags, fmt.Sprintf("-capacity: negative capacity %d", opts.realtime.PassengerCapacity))
+	}
+	realtime := realtimeFeeds{
+		tripUpdates:       opts.realtime.TripUpdates,
+		vehiclePositions:  opts.realtime.VehiclePositions,
+		passengerCapacity: opts.realtime.PassengerCapacity,
+	}
+	var err error
+	if realtime.interval, err = time.ParseDuration(opts.realtime.Interval); err != nil || realtime.interval <= 0 {
+		return opts.usageError(flags, fmt.Sprintf("-realtime-interval: invalid duration %q", opts.realtime.Interval))
+	}
+	registry := opts.settings.registry()
+	if opts.zipFile == "" && *feeds == "" && registry.Default == "" {
+		return opts.usageError(flags, "expected a GTFS zip file argument, -feeds or a config default_agency")
+	}
+	if *feeds != "" {
+		if registry, err = readFeedRegistry(*feeds); err != nil {
+			return opts.usageError(flags, err.Error())
+		}
+	}
+	var scheduler *timetable.Scheduler
+	if opts.zipFile != "" {
+		var status int
+		if scheduler, status = opts.load(); scheduler == nil {
+			return status
+		}
+	} else if status := opts.open(); status != exitOK {
+		return status
+	}
+	return opts.finish(httpServer(splitList(opts.addrs), registry, realtime, scheduler, opts.loadFeed))
+}
+
+func validateCommand(args []string) int {
+	var opts options
+	flags := newFlagSet("validate", "<GTFS zip>", &opts, "csv", "json", "html")
+	if ok, status := opts.parse(flags, args); !ok {
+		return status
+	}
+	scheduler, status := opts.load()
+	if scheduler == nil {
+		return status
+	}
+	feed := scheduler.Feed
+	validation := scheduler.Validate(opts.zipFile)
+	fmt.Printf("%s: %d agencies, %d stops, %d routes, %d trips, %d services, %d errors, %d warnings\n",
+		opts.zipFile, len(feed.Agencies), len(feed.Stops), len(feed.Routes), len(feed.Trips), len(feed.Services),
+		validation.Errors, validation.Warnings)
+	if err := processValidation(scheduler, validation, opts.format); err != nil {
+		return opts.finish(err)
+	}
+	if validation.Errors > 0 {
+		return opts.finish(fmt.Errorf("%s: %d validation errors", opts.zipFile, validation.Errors))
+	}
+	return opts.finish(nil)
+}
+
+func infoCommand(args []string) int {
+	var opts options
+	flags := newFlagSet("info", "<GTFS zip>", &opts)
+	if ok, status := opts.parse(flags, args); !ok {
+		return status
+	}
+	scheduler, status := opts.load()
+	if scheduler == nil {
+		return status
+	}
+	feed := scheduler.Feed
+	fmt.Printf("Feed: %s\n", opts.zipFile)
+	for _, feedInfo := range feed.FeedInfos {
+		fmt.Printf("Publisher: %s Version: %s From: %s - To: %s\n", feedInfo.Publisher_name, feedInfo.Version,
+			timetable.Datestamp(feedInfo.Start_date), timetable.Datestamp(feedInfo.End_date))
+	}
+	fmt.Printf("Agencies: %d\n", len(feed.Agencies))
+	for _, agency := range feed.Agencies {
+		fmt.Printf("  %s %s (%s, %s)\n", agency.Id, agency.Name, agency.Timezone.GetTzString(), agency.Lang.GetLangString())
+	}
+	fmt.Printf("Stops: %d Routes: %d Trips: %d (%d more from frequencies.txt) Blocks: %d Fare attributes: %d\n",
+		len(feed.Stops), len(feed.Routes), len(feed.Trips), scheduler.FrequencyTrips(), len(scheduler.Blocktables), len(feed.FareAttributes))
+	fmt.Printf("Services: %d\n", len(feed.Services))
+	var serviceIDs []string
+	for serviceID := range feed.Services {
+		serviceIDs = append(serviceIDs, serviceID)
+	}
+	sort.Strings(serviceIDs)
+	for _, serviceID := range serviceIDs {
+		service := feed.Services[serviceID]
+		days := ""
+		for weekday, active := range service.Daymap {
+			if active {
+				days += "SMTWTFS"[weekday : weekday+1]
+			} else {
+				days += "-"
+			}
+		}
+		fmt.Printf("  %s %s - %s %s exceptions: %d\n", service.Id, timetable.Datestamp(service.Start_date),
+			timetable.Datestamp(service.End_date), days, len(service.Exceptions))
+	}
+	return opts.finish(nil)
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	"transitrhythm.com/gtfs-parse/timetable"
+)
+
+// envPrefix - the prefix of the environment variables overriding the config file
+const envPrefix = "GTFS_PARSE_"
+
+// config - the settings of a YAML or JSON config file. Settings left empty keep the command defaults, and command line
+// flags override both the file and the environment
+type config struct {
+	Log           string                     `json:"log" yaml:"log"`
+	OutputDir     string                     `json:"output_dir" yaml:"output_dir"`
+	OutputNames   map[string]string          `json:"output_names" yaml:"output_names"`
+	Templates     string                     `json:"templates" yaml:"templates"`
+	Paper         string                     `json:"paper" yaml:"paper"`
+	StartOfDay    string                     `json:"start_of_day" yaml:"start_of_day"`
+	Lang          string                     `json:"lang" yaml:"lang"`
+	MinLayover    string                     `json:"min_layover" yaml:"min_layover"`
+	HTTP          httpConfig                 `json:"http" yaml:"http"`
+	Realtime      realtimeConfig             `json:"realtime" yaml:"realtime"`
+	Deadheads     deadheadConfig             `json:"deadheads" yaml:"deadheads"`
+	Headways      headwayConfig              `json:"headways" yaml:"headways"`
+	Authorities   map[string]authorityConfig `json:"authorities" yaml:"authorities"`
+	Agencies      map[string]agencyConfig    `json:"agencies" yaml:"agencies"`
+	DefaultAgency string                     `json:"default_agency" yaml:"default_agency"`
+}
+
+// httpConfig - the addresses the server listens on, such as "localhost:8081" or ":8080"
+type httpConfig struct {
+	Addrs []string `json:"addrs" yaml:"addrs"`
+}
+
+// realtimeConfig - the GTFS-RT feeds the server polls, each a file or an http:// URL, the polling interval such as
+// "30s" and the passengers per vehicle from which the loads are estimated
+type realtimeConfig struct {
+	TripUpdates       string `json:"trip_updates" yaml:"trip_updates"`
+	VehiclePositions  string `json:"vehicle_positions" yaml:"vehicle_positions"`
+	Interval          string `json:"interval" yaml:"interval"`
+	PassengerCapacity int    `json:"passenger_capacity" yaml:"passenger_capacity"`
+}
+
+// deadheadConfig - the garage the blocks pull out from & pull in to, as "lat,lon", the average deadhead speed in km/h
+// and how the deadheads between stops are estimated, straight-line or shape
+type deadheadConfig struct {
+	Garage     string  `json:"garage" yaml:"garage"`
+	GarageName string  `json:"garage_name" yaml:"garage_name"`
+	Speed      float64 `json:"speed" yaml:"speed"`
+	Estimate   string  `json:"estimate" yaml:"estimate"`
+}
+
+// headwayConfig - the comma separated HH:MM-HH:MM time bands of the headway report, and the longest headway such
+// as "15m" through the HH:MM-HH:MM window of the frequent network
+type headwayConfig struct {
+	Bands    string `json:"bands" yaml:"bands"`
+	Frequent string `json:"frequent" yaml:"frequent"`
+	Window   string `json:"window" yaml:"window"`
+}
+
+// authorityConfig - a transit authority of the feed registry
+type authorityConfig struct {
+	ID   int    `json:"id" yaml:"id"`
+	Name string `json:"name" yaml:"name"`
+}
+
+// agencyConfig - a transit agency of the feed registry and the path of its GTFS zip
+type agencyConfig struct {
+	Authority string `json:"authority" yaml:"authority"`
+	ID        int    `json:"id" yaml:"id"`
+	Name      string `json:"name" yaml:"name"`
+	Feed      string `json:"feed" yaml:"feed"`
+}
+
+// configEnv - the environment variable overriding each config setting
+var configEnv = []struct {
+	name    string
+	setting func(c *config) *string
+}{
+	{"LOG", func(c *config) *string { return &c.Log }},
+	{"OUTPUT_DIR", func(c *config) *string { return &c.OutputDir }},
+	{"TEMPLATES", func(c *config) *string { return &c.Templates }},
+	{"PAPER", func(c *config) *string { return &c.Paper }},
+	{"START_OF_DAY", func(c *config) *string { return &c.StartOfDay }},
+	{"LANG", func(c *config) *string { return &c.Lang }},
+	{"MIN_LAYOVER", func(c *config) *string { return &c.MinLayover }},
+	{"DEFAULT_AGENCY", func(c *config) *string { return &c.DefaultAgency }},
+	{"REALTIME_TRIP_UPDATES", func(c *config) *string { return &c.Realtime.TripUpdates }},
+	{"REALTIME_VEHICLE_POSITIONS", func(c *config) *string { return &c.Realtime.VehiclePositions }},
+	{"REALTIME_INTERVAL", func(c *config) *string { return &c.Realtime.Interval }},
+	{"DEADHEAD_GARAGE", func(c *config) *string { return &c.Deadheads.Garage }},
+	{"DEADHEAD_GARAGE_NAME", func(c *config) *string { return &c.Deadheads.GarageName }},
+	{"DEADHEAD_ESTIMATE", func(c *config) *string { return &c.Deadheads.Estimate }},
+	{"HEADWAY_BANDS", func(c *config) *string { return &c.Headways.Bands }},
+	{"HEADWAY_FREQUENT", func(c *config) *string { return &c.Headways.Frequent }},
+	{"HEADWAY_WINDOW", func(c *config) *string { return &c.Headways.Window }},
+}
+
+// readConfig - reads the config file, if any, and applies the environment variable overrides. Relative paths in the
+// file are taken from its directory. Unknown settings and invalid values are errors
+func readConfig(filename string) (*config, error) {
+	c := config{}
+	if filename != "" {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("config: %v", err)
+		}
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".json":
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.DisallowUnknownFields()
+			err = decoder.Decode(&c)
+		case ".yaml", ".yml":
+			err = yaml.UnmarshalStrict(data, &c)
+		default:
+			err = fmt.Errorf("unknown format, expected a .yaml, .yml or .json file")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("config %s: %v", filename, err)
+		}
+		c.resolvePaths(filepath.Dir(filename))
+	}
+	c.applyEnv()
+	if err := c.validate(); err != nil {
+		if filename == "" {
+			return nil, fmt.Errorf("config: %v", err)
+		}
+		return nil, fmt.Errorf("config %s: %v", filename, err)
+	}
+	return &c, nil
+}
+
+// resolvePaths - makes the relative paths of the config file relative to its directory
+func (c *config) resolvePaths(dir string) {
+	resolve := func(path string) string {
+		if path == "" || path == "-" || filepath.IsAbs(path) || strings.Contains(path, "://") {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+	c.Log = resolve(c.Log)
+	c.OutputDir = resolve(c.OutputDir)
+	c.Templates = resolve(c.Templates)
+	c.Realtime.TripUpdates = resolve(c.Realtime.TripUpdates)
+	c.Realtime.VehiclePositions = resolve(c.Realtime.VehiclePositions)
+	for key, a := range c.Agencies {
+		a.Feed = resolve(a.Feed)
+		c.Agencies[key] = a
+	}
+}
+
+// applyEnv - overrides the settings from GTFS_PARSE_<SETTING>, GTFS_PARSE_HTTP_ADDRS (comma separated) and
+// GTFS_PARSE_FEED_<AGENCY> for the feed of each agency key
+func (c *config) applyEnv() {
+	for _, env := range configEnv {
+		if value, ok := os.LookupEnv(envPrefix + env.name); ok {
+			*env.setting(c) = value
+		}
+	}
+	if value, ok := os.LookupEnv(envPrefix + "HTTP_ADDRS"); ok {
+		c.HTTP.Addrs = splitList(value)
+	}
+	for key, a := range c.Agencies {
+		if value, ok := os.LookupEnv(envPrefix + "FEED_" + strings.ToUpper(key)); ok {
+			a.Feed = value
+			c.Agencies[key] = a
+		}
+	}
+}
+
+// splitList - the non-empty elements of a comma separated list
+func splitList(list string) (values []string) {
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// validate - checks each setting, reporting the first invalid one
+func (c *config) validate() error {
+	if c.StartOfDay != "" {
+		if _, err := timetable.ParseTime(c.StartOfDay); err != nil {
+			return fmt.Errorf("start_of_day: %v", err)
+		}
+	}
+	if c.Paper != "" {
+		if _, err := timetable.ParsePaperSize(c.Paper); err != nil {
+			return fmt.Errorf("paper: %v", err)
+		}
+	}
+	if c.Lang != "" {
+		langs := timetable.Languages()
+		if !contains(langs, c.Lang) {
+			return fmt.Errorf("lang: unsupported language %q, expected one of %s", c.Lang, strings.Join(langs, ", "))
+		}
+	}
+	if c.MinLayover != "" {
+		if minLayover, err := time.ParseDuration(c.MinLayover); err != nil || minLayover < 0 {
+			return fmt.Errorf("min_layover: invalid duration %q", c.MinLayover)
+		}
+	}
+	for kind, pattern := range c.OutputNames {
+		if err := validateOutputName(kind, pattern); err != nil {
+			return fmt.Errorf("output_names: %v", err)
+		}
+	}
+	for _, addr := range c.HTTP.Addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("http.addrs: %v", err)
+		}
+	}
+	if c.Realtime.Interval != "" {
+		if interval, err := time.ParseDuration(c.Realtime.Interval); err != nil || interval <= 0 {
+			return fmt.Errorf("realtime.interval: invalid duration %q", c.Realtime.Interval)
+		}
+	}
+	if c.Realtime.PassengerCapacity < 0 {
+		return fmt.Errorf("realtime.passenger_capacity: negative capacity %d", c.Realtime.PassengerCapacity)
+	}
+	if c.Deadheads.Garage != "" {
+		if _, err := timetable.ParseGarage(c.Deadheads.GarageName, c.Deadheads.Garage); err != nil {
+			return fmt.Errorf("deadheads.garage: %v", err)
+		}
+	}
+	if c.Deadheads.Speed < 0 {
+		return fmt.Errorf("deadheads.speed: negative speed %g", c.Deadheads.Speed)
+	}
+	if methods := timetable.EstimateMethods(); c.Deadheads.Estimate != "" && !contains(methods, c.Deadheads.Estimate) {
+		return fmt.Errorf("deadheads.estimate: unknown estimate %q, expected one of %s", c.Deadheads.Estimate, strings.Join(methods, ", "))
+	}
+	if c.Headways.Bands != "" {
+		if _, err := timetable.ParseTimeBands(c.Headways.Bands); err != nil {
+			return fmt.Errorf("headways.bands: %v", err)
+		}
+	}
+	if c.Headways.Frequent != "" {
+		if frequent, err := time.ParseDuration(c.Headways.Frequent); err != nil || frequent <= 0 {
+			return fmt.Errorf("headways.frequent: invalid duration %q", c.Headways.Frequent)
+		}
+	}
+	if c.Headways.Window != "" {
+		if _, err := timetable.ParseTimeBand(c.Headways.Window); err != nil {
+			return fmt.Errorf("headways.window: %v", err)
+		}
+	}
+	ids := make(map[int]string)
+	for key, a := range c.Authorities {
+		if other, ok := ids[a.ID]; ok {
+			return fmt.Errorf("authorities: %s and %s have the same id %d", other, key, a.ID)
+		}
+		ids[a.ID] = key
+	}
+	for key, a := range c.Agencies {
+		if a.Feed == "" {
+			return fmt.Errorf("agencies.%s: missing feed", key)
+		}
+		if _, ok := c.Authorities[a.Authority]; !ok {
+			return fmt.Errorf("agencies.%s: unknown authority %q", key, a.Authority)
+		}
+	}
+	if _, ok := c.Agencies[c.DefaultAgency]; c.DefaultAgency != "" && !ok {
+		return fmt.Errorf("default_agency: %q is not one of the agencies", c.DefaultAgency)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// registry - the feed registry of the config authorities & agencies
+func (c *config) registry() *feedRegistry {
+	registry := feedRegistry{Authorities: map[string]authority{}, Agencies: map[string]agency{}, Default: c.DefaultAgency}
+	for key, a := range c.Authorities {
+		registry.Authorities[key] = authority{a.ID, a.Name}
+	}
+	for key, a := range c.Agencies {
+		registry.Agencies[key] = agency{c.Authorities[a.Authority].ID, a.ID, a.Name, a.Feed}
+	}
+	return &registry
+}
+
+// outputNames - the default filename pattern of each report, where {name} is the stop, route or block, {start} &
+// {end} the report dates, {date} the service date, {direction} the route direction and {format} the file extension
+var outputNames = map[string]string{
+	"timetable-week":    "Timetable-{name}-WE-{end}.{format}",
+	"timetable-range":   "Timetable-{name}-{start}-{end}.{format}",
+	"route-timetable":   "RouteTimetable-{name}-{direction}-{date}.{format}",
+	"block-week":        "BlockWeek-{name}-WE-{end}.{format}",
+	"block-range":       "BlockSchedule-{name}-{start}-{end}.{format}",
+	"block-month":       "BlockMonth-{start}.{format}",
+	"block-calendar":    "BlockCalendar-{start}-{end}.{format}",
+	"deadhead-week":     "DeadheadWeek-{end}.{format}",
+	"block-check-week":  "BlockCheck-{name}-WE-{end}.{format}",
+	"block-check-range": "BlockCheck-{name}-{start}-{end}.{format}",
+	"stats-week":        "Statistics-WE-{end}.{format}",
+	"stats-range":       "Statistics-{start}-{end}.{format}",
+	"fleet-week":        "Fleet-WE-{end}.{format}",
+	"fleet-range":       "Fleet-{start}-{end}.{format}",
+	"headways-week":     "Headways-{name}-WE-{end}.{format}",
+	"headways-range":    "Headways-{name}-{start}-{end}.{format}",
+	"validation":        "Validation-{date}.{format}",
+}
+
+var outputFields = regexp.MustCompile(`{[^}]*}`)
+
+// validateOutputName - checks that the report is known and the pattern only uses known fields
+func validateOutputName(kind, pattern string) error {
+	if _, ok := outputNames[kind]; !ok {
+		var kinds []string
+		for k := range outputNames {
+			kinds = append(kinds, k)
+		}
+		sort.Strings(kinds)
+		return fmt.Errorf("unknown report %q, expected one of %s", kind, strings.Join(kinds, ", "))
+	}
+	for _, field := range outputFields.FindAllString(pattern, -1) {
+		switch field {
+		case "{name}", "{start}", "{end}", "{date}", "{direction}", "{format}":
+		default:
+			return fmt.Errorf("%s: unknown field %s", kind, field)
+		}
+	}
+	if strings.ContainsAny(pattern, `/\`) {
+		return fmt.Errorf("%s: %q is not a file name", kind, pattern)
+	}
+	return nil
+}
+
+// reportName - the field values of a report filename pattern
+type reportName struct {
+	name, start, end, date, direction, format string
+}
+
+// filename - the filename of the report from its pattern in outputNames
+func (n reportName) filename(kind string) string {
+	return strings.NewReplacer("{name}", n.name, "{start}", n.start, "{end}", n.end, "{date}", n.date,
+		"{direction}", n.direction, "{format}", n.format).Replace(outputNames[kind])
+}
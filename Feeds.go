@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"transitrhythm.com/gtfs-parse/timetable"
+)
+
+// defaultFeed - the agency key of the GTFS zip given on the serve command line
+const defaultFeed = "default"
+
+// feedRegistry - the transit authorities & agencies the server can switch between, mapping each agency key to the
+// path of its GTFS zip
+type feedRegistry struct {
+	Authorities map[string]authority `json:"authorities"`
+	Agencies    map[string]agency    `json:"agencies"`
+	Default     string               `json:"default_agency"` // key of the agency whose feed is loaded when the server starts
+}
+
+// readFeedRegistry - reads the JSON feed registry, which has the authorities, agencies & default_agency of a config
+// file. Relative paths are taken from the directory of the registry file. Unknown fields, agencies without a feed or
+// an authority and a default that is not one of the agencies are errors
+func readFeedRegistry(filename string) (*feedRegistry, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("feed registry: %v", err)
+	}
+	defer file.Close()
+	c := config{}
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&c); err != nil {
+		return nil, fmt.Errorf("feed registry %s: %v", filename, err)
+	}
+	c.resolvePaths(filepath.Dir(filename))
+	if err = c.validate(); err != nil {
+		return nil, fmt.Errorf("feed registry %s: %v", filename, err)
+	}
+	return c.registry(), nil
+}
+
+// authorityKey - the key of the authority with the AuthorityID, or empty if there is none
+func authorityKey(authorities map[string]authority, authorityID int) string {
+	for key, a := range authorities {
+		if a.AuthorityID == authorityID {
+			return key
+		}
+	}
+	return ""
+}
+
+// feedStatus - the JSON response of /statz/setAgency and /statz/setAuthority
+type feedStatus struct {
+	Authority string
+	Agency    string
+	Feed      string
+	Loaded    []string // keys of the agencies whose feeds are in memory
+	Agencies  map[string]agency
+}
+
+func (s *server) status() feedStatus {
+	status := feedStatus{Authority: s.authority, Agency: s.agency, Loaded: []string{}, Agencies: make(map[string]agency)}
+	if a, ok := s.agencies[s.agency]; ok {
+		status.Feed = a.Feed
+	}
+	for key := range s.feeds {
+		status.Loaded = append(status.Loaded, key)
+	}
+	sort.Strings(status.Loaded)
+	for key, a := range s.agencies {
+		if s.authority == "" || s.authorities[s.authority].AuthorityID == a.AuthorityID {
+			status.Agencies[key] = a
+		}
+	}
+	return status
+}
+
+// addFeed - keeps the loaded feed in memory under the agency key and makes it the active feed, dropping the TripUpdates
+// and vehicle states matched against the previous one
+func (s *server) addFeed(key string, scheduler *timetable.Scheduler) {
+	if s.feeds[key] == nil {
+		s.feeds[key] = scheduler
+	}
+	if s.scheduler != s.feeds[key] {
+		s.tripUpdates = tripUpdatesStatus{Source: s.tripUpdates.Source, Trips: []*timetable.TripDelays{}}
+		s.tripDelays = nil
+		s.vehiclePositions.Vehicles = []*vehicleLoad{}
+		s.vehicles = nil
+		s.trackTrip("")
+	}
+	s.agency = key
+	s.scheduler = s.feeds[key]
+	if a, ok := s.agencies[key]; ok {
+		s.authority = authorityKey(s.authorities, a.AuthorityID)
+	}
+}
+
+// switchFeed - makes the feed of the agency key active, loading it first unless it is already in memory. The feed is
+// parsed without holding the lock so that requests for the other feeds carry on meanwhile
+func (s *server) switchFeed(key string) error {
+	s.RLock()
+	a, ok := s.agencies[key]
+	scheduler := s.feeds[key]
+	s.RUnlock()
+	if !ok && scheduler == nil {
+		return &notFoundError{"agency", key}
+	}
+	if scheduler == nil {
+		var err error
+		if scheduler, err = s.loadFeed(a.Feed); err != nil {
+			return err
+		}
+	}
+	s.Lock()
+	s.addFeed(key, scheduler)
+	s.Unlock()
+	return nil
+}
+
+// feed - the loaded feed of the agency key, or the active feed if the key is empty. Feeds are loaded by
+// /statz/setAgency. The caller holds the read lock
+func (s *server) feed(key string) (*timetable.Scheduler, error) {
+	if key == "" {
+		key = s.agency
+	}
+	scheduler := s.feeds[key]
+	if scheduler == nil {
+		if _, ok := s.agencies[key]; ok {
+			return nil, &notFoundError{"loaded feed for agency", key}
+		}
+		return nil, &notFoundError{"agency", key}
+	}
+	return scheduler, nil
+}
+
+// selectOptions - the HTML options of a drop-down list of the keys & descriptions, ordered by description
+func selectOptions(descriptors map[string]string, selected string) string {
+	var keys []string
+	for key := range descriptors {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return descriptors[keys[i]] < descriptors[keys[j]] })
+	var options []string
+	for _, key := range keys {
+		attribute := ""
+		if key == selected {
+			attribute = " selected"
+		}
+		options = append(options, fmt.Sprintf(`<option value="%s"%s>%s</option>`, html.EscapeString(key), attribute, html.EscapeString(descriptors[key])))
+	}
+	return strings.Join(options, "\n\t\t")
+}
+
+// dropdown - the authority & agency drop-down lists of the statz page
+func (s *server) dropdown() string {
+	authorities := make(map[string]string)
+	for key, a := range s.authorities {
+		authorities[key] = a.Descriptor
+	}
+	agencies := make(map[string]string)
+	for key, a := range s.status().Agencies {
+		agencies[key] = a.Descriptor
+	}
+	return fmt.Sprintf(HTMLDropdown, selectOptions(authorities, s.authority), selectOptions(agencies, s.agency))
+}
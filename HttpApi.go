@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"transitrhythm.com/gtfs-parse/timetable"
+)
+
+// apiPrefix - the path of the read-only REST API, which serves the loaded feed as JSON
+const apiPrefix = "/api/v1/"
+
+const jsonContentType = "application/json; charset=utf-8"
+
+// notFoundError - a request for a trip, stop, route, block or service that is not in the feed
+type notFoundError struct {
+	kind string
+	id   string
+}
+
+func (e *notFoundError) Error() string {
+	return fmt.Sprintf("no %s with id %q", e.kind, e.id)
+}
+
+// badRequestError - a request with an invalid query parameter
+type badRequestError string
+
+func (e badRequestError) Error() string {
+	return string(e)
+}
+
+// errorStatus - the HTTP status code of a request error
+func errorStatus(err error) int {
+	switch err.(type) {
+	case *notFoundError:
+		return http.StatusNotFound
+	case badRequestError:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// apiError - the JSON body of an API error response
+type apiError struct {
+	Status int
+	Error  string
+}
+
+// apiResource - a collection of the API, whose items are addressed by the path element after its name
+type apiResource struct {
+	name string
+	find func(scheduler *timetable.Scheduler, id string, r *http.Request) (interface{}, error)
+}
+
+var apiResources = []apiResource{
+	{"trips", apiTrip},
+	{"stops", apiStop},
+	{"routes", apiRoute},
+	{"blocks", apiBlock},
+	{"services", apiService},
+	{"stats", apiStats},
+}
+
+// handleAPI - registers the handler of each API resource
+func (s *server) handleAPI() {
+	for _, resource := range apiResources {
+		http.HandleFunc(apiPrefix+resource.name+"/", s.apiHandler(resource))
+	}
+}
+
+// apiPaths - the path pattern of each API resource
+func apiPaths() (paths []string) {
+	for _, resource := range apiResources {
+		paths = append(paths, apiPrefix+resource.name+"/{id}")
+	}
+	return paths
+}
+
+// apiHandler - serves GET requests for an item of the resource as JSON from the active feed, or the loaded feed of the
+// ?agency= key, with a JSON error body for a missing item
+func (s *server) apiHandler(resource apiResource) http.HandlerFunc {
+	prefix := apiPrefix + resource.name + "/"
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeJSON(w, http.StatusMethodNotAllowed, apiError{http.StatusMethodNotAllowed, "method " + r.Method + " not allowed"})
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, prefix)
+		if id == "" {
+			writeJSON(w, http.StatusNotFound, apiError{http.StatusNotFound, "missing " + resource.name + " id"})
+			return
+		}
+		s.RLock()
+		defer s.RUnlock()
+		scheduler, err := s.feed(r.URL.Query().Get("agency"))
+		var item interface{}
+		if err == nil {
+			item, err = resource.find(scheduler, id, r)
+		}
+		if err != nil {
+			log.Println(r.URL.Path, err)
+			status := errorStatus(err)
+			writeJSON(w, status, apiError{status, err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, item)
+	}
+}
+
+// writeJSON - writes the value as a JSON response with the status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(status)
+	_, err = w.Write(data)
+	return err
+}
+
+// apiTrip - /api/v1/trips/{id} is the trip and its stop times, /api/v1/trips/{id}/stops its stop points, or only its
+// timing points with ?timing=true
+func apiTrip(scheduler *timetable.Scheduler, id string, r *http.Request) (interface{}, error) {
+	if tripID := strings.TrimSuffix(id, "/stops"); tripID != id && scheduler.FindTrip(id, "") == nil {
+		data, err := GetStopPointsForTripJSON(scheduler, tripID, r.URL.Query().Get("timing") == "true")
+		return json.RawMessage(data), err
+	}
+	if tripDetail := scheduler.FindTripDetail(id); tripDetail != nil {
+		return tripDetail, nil
+	}
+	return nil, &notFoundError{"trip", id}
+}
+
+// apiStop - /api/v1/stops/{code} is the stop with the stop_code and the routes calling at it, and
+// /api/v1/stops/{code}/departures its next departures
+func apiStop(scheduler *timetable.Scheduler, code string, r *http.Request) (interface{}, error) {
+	if stopCode := strings.TrimSuffix(code, "/departures"); stopCode != code && scheduler.FindStop(code) == nil {
+		return stopDepartures(scheduler, stopCode, r.URL.Query())
+	}
+	if stopDetail := scheduler.FindStopDetail(code); stopDetail != nil {
+		return stopDetail, nil
+	}
+	return nil, &notFoundError{"stop", code}
+}
+
+// apiRoute - /api/v1/routes/{id} is the route and its trip IDs
+func apiRoute(scheduler *timetable.Scheduler, id string, r *http.Request) (interface{}, error) {
+	if routeDetail := scheduler.FindRouteDetail(id); routeDetail != nil {
+		return routeDetail, nil
+	}
+	return nil, &notFoundError{"route", id}
+}
+
+// apiBlock - /api/v1/blocks/{id} is the trips of the block
+func apiBlock(scheduler *timetable.Scheduler, id string, r *http.Request) (interface{}, error) {
+	if blockDetail := scheduler.FindBlockDetail(id); blockDetail != nil {
+		return blockDetail, nil
+	}
+	return nil, &notFoundError{"block", id}
+}
+
+// apiService - /api/v1/services/{id} is the service calendar, its exceptions and trip count
+func apiService(scheduler *timetable.Scheduler, id string, r *http.Request) (interface{}, error) {
+	if serviceDetail := scheduler.FindServiceDetail(id); serviceDetail != nil {
+		return serviceDetail, nil
+	}
+	return nil, &notFoundError{"service", id}
+}
+
+// maxStatsDays - the longest date range of the statistics API
+const maxStatsDays = 366
+
+// apiStats - /api/v1/stats/{date} is the revenue & deadhead statistics of the date by route, block & service, and
+// /api/v1/stats/{start}/{end} those of each date of the range with their totals
+func apiStats(scheduler *timetable.Scheduler, id string, r *http.Request) (interface{}, error) {
+	dates := strings.SplitN(id, "/", 2)
+	start, err := timetable.ParseDate(dates[0])
+	if err != nil {
+		return nil, badRequestError("start: " + err.Error())
+	}
+	end := start
+	if len(dates) == 2 {
+		if end, err = timetable.ParseDate(dates[1]); err != nil {
+			return nil, badRequestError("end: " + err.Error())
+		}
+	}
+	if timetable.DateBefore(end, start) {
+		return nil, badRequestError(fmt.Sprintf("end %s is before start %s", timetable.Datestamp(end), timetable.Datestamp(start)))
+	}
+	if timetable.DateBefore(timetable.DateAdd(start, maxStatsDays-1), end) {
+		return nil, badRequestError(fmt.Sprintf("more than %d days from %s", maxStatsDays, timetable.Datestamp(start)))
+	}
+	return scheduler.CreateOperatingReport(start, end), nil
+}
@@ -3,18 +3,24 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"html"
 	"image/color"
 	"log"
-	"math/rand"
+	"math"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
 	"github.com/pkg/errors"
 	"gonum.org/v1/plot"
 	"gonum.org/v1/plot/plotter"
 	"gonum.org/v1/plot/vg/draw"
+	"transitrhythm.com/gtfs-parse/timetable"
 )
 
 type server struct {
@@ -29,17 +35,27 @@ type server struct {
 	authorities       map[string]authority
 	agencies          map[string]agency
 	stops             map[string]stop
+	feeds             map[string]*timetable.Scheduler // loaded feeds by agency key
+	authority         string                          // key of the selected authority
+	agency            string                          // key of the agency whose feed is active
+	scheduler         *timetable.Scheduler            // active feed
+	loadFeed          func(zipFile string) (*timetable.Scheduler, error)
+	tripUpdates       tripUpdatesStatus                // last read of the GTFS-RT TripUpdates feed
+	tripDelays        map[string]*timetable.TripDelays // TripUpdates of the active feed by trip_id
+	vehiclePositions  vehiclePositionsStatus           // last read of the GTFS-RT VehiclePositions feed
+	vehicles          map[string]*vehicleLoad          // state of each vehicle on a trip, by VehicleState.Key
 }
 
 type authority struct {
-	AuthorityID int
-	Descriptor  string
+	AuthorityID int    `json:"id"`
+	Descriptor  string `json:"name"`
 }
 
 type agency struct {
-	AuthorityID int
-	AgencyID    int
-	Descriptor  string
+	AuthorityID int    `json:"authority_id"`
+	AgencyID    int    `json:"id"`
+	Descriptor  string `json:"name"`
+	Feed        string `json:"feed"` // path of the GTFS zip of the agency
 }
 
 type stop struct {
@@ -54,231 +70,606 @@ type stop struct {
 	DistanceTraveled float32
 }
 
-func setupAuthorities() map[string]authority {
-	authorities := map[string]authority{
-		"bcTransit":          {1, "BC Transit"},
-		"vancouverTranslink": {2, "Vancouver Translink"},
-	}
-	return authorities
-}
-
-func setupAgencies() (agencies map[string]agency) {
-	agencies = map[string]agency{
-		"comox":    {1, 12, "Comox Valley Transit System"},
-		"kamloops": {1, 8, "Kamloops Transit System"},
-		"kelowna":  {1, 7, "Kelowna Regional Transit System"},
-		"nanaimo":  {1, 5, "RDN Transit System"},
-		"squamish": {1, 4, "Squamish Transit System"},
-		"victoria": {1, 1, "Victoria Regional Transit System"},
-		"whistler": {1, 3, "Whistler Transit System"},
-	}
-	return agencies
-}
-
+// errorHandler - serves the request, answering an error of the handler with its status and a JSON error body
 func errorHandler(h func(http.ResponseWriter, *http.Request) error) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		err := h(w, r)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			log.Println(r.URL.Path, err)
+			status := errorStatus(err)
+			writeJSON(w, status, apiError{status, err.Error()})
 		}
 	}
 }
 
+// getAuthorities - /statz/getAuthorities lists the authorities of the registry
 func (s *server) getAuthorities(w http.ResponseWriter, r *http.Request) error {
 	s.RLock()
 	defer s.RUnlock()
-	data, err := json.Marshal(s.authorities)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	jsonData := string(data)
-	fmt.Fprintf(w, "%s", jsonData)
-	return nil
+	return writeJSON(w, http.StatusOK, s.authorities)
 }
 
+// setAuthority - /statz/setAuthority?authority=key selects the authority whose agencies are listed
 func (s *server) setAuthority(w http.ResponseWriter, r *http.Request) error {
-	s.RLock()
-	defer s.RUnlock()
-
-	return nil
+	s.Lock()
+	defer s.Unlock()
+	key := r.URL.Query().Get("authority")
+	if _, ok := s.authorities[key]; !ok && key != "" {
+		return &notFoundError{"authority", key}
+	}
+	s.authority = key
+	return writeJSON(w, http.StatusOK, s.status())
 }
 
+// getAgencies - /statz/getAgencies lists the agencies of the registry
 func (s *server) getAgencies(w http.ResponseWriter, r *http.Request) error {
 	s.RLock()
 	defer s.RUnlock()
-	data, err := json.Marshal(s.agencies)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	jsonData := string(data)
-	fmt.Fprintf(w, "%s", jsonData)
-	return nil
+	return writeJSON(w, http.StatusOK, s.agencies)
 }
 
+// setAgency - /statz/setAgency?agency=key makes the feed of the agency active, loading it unless it is in memory
 func (s *server) setAgency(w http.ResponseWriter, r *http.Request) error {
+	if err := s.switchFeed(r.URL.Query().Get("agency")); err != nil {
+		return err
+	}
 	s.RLock()
 	defer s.RUnlock()
-
-	// setupFeed(agency)
-
-	return nil
+	return writeJSON(w, http.StatusOK, s.status())
 }
 
-func httpServer() {
+// httpServer - serves the feeds of the registry on each of the addresses, starting with the feed of the command line under defaultFeed if
+// there is one, or else the Default feed of the registry. The GTFS-RT feeds are polled against the active feed
+func httpServer(addrs []string, registry *feedRegistry, realtime realtimeFeeds, scheduler *timetable.Scheduler, loadFeed func(string) (*timetable.Scheduler, error)) error {
 	var s server
-	s.authorities = setupAuthorities()
-	s.agencies = setupAgencies()
+	s.authorities = registry.Authorities
+	s.agencies = registry.Agencies
+	s.feeds = make(map[string]*timetable.Scheduler)
+	s.loadFeed = loadFeed
+	if scheduler != nil {
+		s.addFeed(defaultFeed, scheduler)
+	} else if registry.Default != "" {
+		if err := s.switchFeed(registry.Default); err != nil {
+			return err
+		}
+	}
 
 	http.HandleFunc("/", errorHandler(s.root))
 	http.HandleFunc("/statz", errorHandler(s.statz))
 	http.HandleFunc("/statz/trip.png", errorHandler(s.trip))
+	http.HandleFunc("/statz/fleet.png", errorHandler(s.fleet))
+	http.HandleFunc("/statz/headways.png", errorHandler(s.headways))
 	http.HandleFunc("/statz/getAuthorities", errorHandler(s.getAuthorities))
 	http.HandleFunc("/statz/getAgencies", errorHandler(s.getAgencies))
 	http.HandleFunc("/statz/setAuthority", errorHandler(s.setAuthority))
 	http.HandleFunc("/statz/setAgency", errorHandler(s.setAgency))
-
-	log.Fatal(http.ListenAndServe("localhost:8081", nil))
+	http.HandleFunc("/statz/tripUpdates", errorHandler(s.getTripUpdates))
+	http.HandleFunc("/statz/setTrip", errorHandler(s.setTrip))
+	http.HandleFunc("/statz/vehicles", errorHandler(s.getVehicles))
+	http.HandleFunc(simulatorPrefix, errorHandler(s.simulator))
+	s.handleAPI()
+	s.startRealtime(realtime)
+
+	errs := make(chan error, len(addrs))
+	for _, addr := range addrs {
+		go func(addr string) {
+			log.Println("Listening on", addr)
+			errs <- http.ListenAndServe(addr, nil)
+		}(addr)
+	}
+	return <-errs
 }
 
+// Tolerances of the adherence plots, within which a trip is on time
+const (
+	earlyTolerance = 1 * time.Minute
+	lateTolerance  = 5 * time.Minute
+)
+
+// maxStatzTrips - the most trip plots shown by the statz page
+const maxStatzTrips = 12
+
+// statz - the adherence page, with a plot of each active trip of ?block=ID or ?route=ID, by default the block of the
+// tracked trip or else its route
 func (s *server) statz(w http.ResponseWriter, r *http.Request) (err error) {
-	var imageSplice []string
 	htmlInterval := 1000
 	htmlColumns := 3
 	htmlZoom := 99 / htmlColumns
-	htmlImage := fmt.Sprintf(`<img src="/statz/trip.png?rand=0" style="width:%d%%">`, htmlZoom)
-	trips := 1
-	for i := 0; i < trips; i++ {
-		imageSplice = append(imageSplice, htmlImage)
+	s.RLock()
+	defer s.RUnlock()
+	scope, tripIDs := s.activeTrips(r.URL.Query())
+	imageSplice := []string{fmt.Sprintf("<h2>Active trips %s</h2>", html.EscapeString(scope))}
+	if len(tripIDs) == 0 {
+		imageSplice = append(imageSplice, "<p><i>No active trips</i></p>")
+	}
+	for _, tripID := range tripIDs {
+		imageSplice = append(imageSplice, fmt.Sprintf(`<img src="/statz/trip.png?trip=%s&rand=0" style="width:%d%%">`,
+			html.EscapeString(url.QueryEscape(tripID)), htmlZoom))
+	}
+	imageSplice = append(imageSplice, `<h2>Vehicles in service today</h2><img src="/statz/fleet.png?rand=0" style="width:99%">`)
+	headways := url.Values{"rand": {"0"}}
+	for _, key := range []string{"stop", "route"} {
+		if value := r.URL.Query().Get(key); value != "" {
+			headways.Set(key, value)
+		}
 	}
+	imageSplice = append(imageSplice, fmt.Sprintf(`<h2>Headways today</h2><img src="/statz/headways.png?%s" style="width:99%%">`,
+		html.EscapeString(headways.Encode())))
 	htmlImages := strings.Join(imageSplice, " ")
-	htmlText := fmt.Sprintf("%s", htmlImages)
 	htmlPrelude := fmt.Sprintf(Prelude, htmlColumns, htmlColumns, htmlColumns)
 	htmlEpilog := fmt.Sprintf(Epilog, htmlInterval)
-	htmlText = htmlPrelude + HTMLDropdown + htmlImages + htmlEpilog
+	htmlText := htmlPrelude + s.dropdown() + s.vehicleTable() + htmlImages + htmlEpilog
 	fmt.Fprintf(w, "%s", htmlText)
 	return err
 }
 
+// activeTrips - the trips of the block or route that have a TripUpdate or are scheduled to be running now, ordered
+// by start, and a description of the block or route. The caller holds the read lock
+func (s *server) activeTrips(query url.Values) (scope string, tripIDs []string) {
+	if s.scheduler == nil {
+		return "", nil
+	}
+	blockID, routeID := query.Get("block"), query.Get("route")
+	if trip := s.scheduler.FindTrip(s.tripID, ""); trip != nil && blockID == "" && routeID == "" {
+		if trip.Block_id != "" {
+			blockID = trip.Block_id
+		} else {
+			routeID = trip.Route.Id
+		}
+	}
+	switch {
+	case blockID != "":
+		scope = "in block " + blockID
+	case routeID != "":
+		scope = "on route " + routeID
+	}
+	inScope := func(trip *gtfs.Trip) bool {
+		return trip != nil && (blockID == "" || trip.Block_id == blockID) && (routeID == "" || trip.Route.Id == routeID)
+	}
+	starts := make(map[string]time.Time)
+	for _, tripDelays := range s.tripUpdates.Trips {
+		if !tripDelays.Canceled && inScope(s.scheduler.FindTrip(tripDelays.TripID, "")) {
+			starts[tripDelays.TripID] = tripDelays.Start
+		}
+	}
+	for _, running := range s.scheduler.RunningTrips(time.Now()) {
+		if _, ok := starts[running.Trip.Id]; !ok && inScope(running.Trip) {
+			starts[running.Trip.Id] = running.Start
+		}
+	}
+	for tripID := range starts {
+		tripIDs = append(tripIDs, tripID)
+	}
+	sort.Slice(tripIDs, func(i, j int) bool {
+		if !starts[tripIDs[i]].Equal(starts[tripIDs[j]]) {
+			return starts[tripIDs[i]].Before(starts[tripIDs[j]])
+		}
+		return tripIDs[i] < tripIDs[j]
+	})
+	if len(tripIDs) > maxStatzTrips {
+		tripIDs = tripIDs[:maxStatzTrips]
+	}
+	return scope, tripIDs
+}
+
+// trip - /statz/trip.png?trip=ID plots the scheduled arrivals of the trip along its distance, between the early &
+// late tolerance bands, and the estimated arrivals of its TripUpdate. Without ?trip= it plots the tracked trip. The
+// trip is looked up in the feed of ?agency=, or else the active feed
 func (s *server) trip(w http.ResponseWriter, r *http.Request) error {
 	s.RLock()
 	defer s.RUnlock()
+	query := r.URL.Query()
+	tripID := query.Get("trip")
+	if tripID == "" {
+		tripID = s.tripID
+	}
+	scheduler, err := s.feed(query.Get("agency"))
+	if err != nil {
+		return err
+	}
+	trip := scheduler.FindTrip(tripID, "")
+	if trip == nil || len(trip.StopTimes) == 0 {
+		return &notFoundError{"trip", tripID}
+	}
+	distances, shapeDist := timetable.StopPointDistances(scheduler.FindStopPointsForTrip(tripID, false))
+	first := trip.StopTimes[0].Departure_time
+	scheduled := make(plotter.XYs, len(trip.StopTimes))
+	band := make(plotter.XYs, 2*len(trip.StopTimes))
+	stops := make(map[int]int)
+	for i, stopTime := range trip.StopTimes {
+		stops[stopTime.Sequence] = i
+		scheduled[i].X = distances[i]
+		scheduled[i].Y = gtfsMinutes(stopTime.Arrival_time) - gtfsMinutes(first)
+		band[i].X, band[i].Y = scheduled[i].X, scheduled[i].Y-earlyTolerance.Minutes()
+		j := len(band) - 1 - i
+		band[j].X, band[j].Y = scheduled[i].X, scheduled[i].Y+lateTolerance.Minutes()
+	}
 
-	xys := make(plotter.XYs, len(s.data))
-	for i, d := range s.data {
-		xys[i].X = float64(i)
-		xys[i].Y = float64(d) / float64(time.Millisecond)
+	p, err := plot.New()
+	if err != nil {
+		return errors.Wrap(err, "could not create plot")
+	}
+	tolerance, err := plotter.NewPolygon(band)
+	if err != nil {
+		return errors.Wrap(err, "could not create tolerance band")
 	}
-	sc, err := plotter.NewScatter(xys)
+	tolerance.Color = color.NRGBA{G: 200, A: 64}
+	tolerance.LineStyle.Width = 0
+	schedule, schedulePoints, err := plotter.NewLinePoints(scheduled)
 	if err != nil {
-		return errors.Wrap(err, "could not create trip")
+		return errors.Wrap(err, "could not create schedule")
+	}
+	schedulePoints.Shape = draw.CircleGlyph{}
+	p.Add(plotter.NewGrid(), tolerance, schedule, schedulePoints)
+	p.Legend.Add("scheduled", schedule, schedulePoints)
+	p.Legend.Add(fmt.Sprintf("-%.0f/+%.0f min", earlyTolerance.Minutes(), lateTolerance.Minutes()), tolerance)
+
+	// The TripUpdates are only those of the active feed
+	if tripDelays := s.tripDelays[tripID]; scheduler == s.scheduler && tripDelays != nil && len(tripDelays.Stops) > 0 {
+		var estimated plotter.XYs
+		for _, stop := range tripDelays.Stops {
+			if i, ok := stops[stop.StopSequence]; ok {
+				estimated = append(estimated, plotter.XY{X: scheduled[i].X, Y: scheduled[i].Y + float64(stop.Delay)/60})
+			}
+		}
+		actual, actualPoints, err := plotter.NewLinePoints(estimated)
+		if err != nil {
+			return errors.Wrap(err, "could not create estimates")
+		}
+		actual.Color = color.RGBA{R: 255, A: 255}
+		actualPoints.Shape = draw.CrossGlyph{}
+		actualPoints.Color = actual.Color
+		p.Add(actual, actualPoints)
+		p.Legend.Add("estimated", actual, actualPoints)
 	}
-	sc.GlyphStyle.Shape = draw.CrossGlyph{}
 
-	avgs := make(plotter.XYs, len(s.data))
-	sum := 0.0
-	for i, d := range s.data {
-		avgs[i].X = float64(i)
-		sum += float64(d)
-		avgs[i].Y = sum / (float64(i+1) * float64(time.Millisecond))
+	heading := fmt.Sprintf("Trip %s profile, route %s", tripID, trip.Route.Id)
+	if tripID == s.tripID && s.vehicleID != "" && s.passengerCapacity > 0 {
+		heading += fmt.Sprintf(", vehicle %s load %d/%d", s.vehicleID, s.passengerLoad, s.passengerCapacity)
 	}
-	l, err := plotter.NewLine(avgs)
+	p.Title.Text = heading
+	p.Y.Label.Text = "Minutes after " + timetable.GTFSTime(first)
+	p.X.Label.Text = "Distance (km, straight line)"
+	if shapeDist {
+		p.X.Label.Text = "Distance (shape_dist_traveled)"
+	}
+	p.Legend.Top = true
+	p.Legend.Left = true
+
+	wt, err := p.WriterTo(512, 288, "png")
 	if err != nil {
-		return errors.Wrap(err, "could not create line")
+		return errors.Wrap(err, "could not create writer to")
 	}
-	l.Color = color.RGBA{G: 255, A: 255}
+	w.Header().Set("Content-Type", "image/png")
+	_, err = wt.WriteTo(w)
+	return errors.Wrap(err, "could not write to output")
+}
 
-	g := plotter.NewGrid()
-	g.Horizontal.Color = color.RGBA{R: 255, A: 255}
-	g.Vertical.Width = 0
+// fleet - /statz/fleet.png plots the vehicles in service at each minute of the service day ?date=YYYY-MM-DD (today
+// by default) over the histogram of the most vehicles in each band of ?interval= (default 15m), of the whole fleet or
+// only of ?route=ID
+func (s *server) fleet(w http.ResponseWriter, r *http.Request) error {
+	s.RLock()
+	defer s.RUnlock()
+	query := r.URL.Query()
+	scheduler, err := s.feed(query.Get("agency"))
+	if err != nil {
+		return err
+	}
+	date := timetable.Today(scheduler.Location())
+	if text := query.Get("date"); text != "" {
+		if date, err = timetable.ParseDate(text); err != nil {
+			return badRequestError("date: " + err.Error())
+		}
+	}
+	interval := timetable.DefaultFleetInterval
+	if text := query.Get("interval"); text != "" {
+		interval, err = time.ParseDuration(text)
+		if err != nil || interval < time.Minute || interval > 24*time.Hour || interval%time.Minute != 0 {
+			return badRequestError(fmt.Sprintf("interval: %q is not a whole number of minutes up to 24h", text))
+		}
+	}
+	day := scheduler.CreateFleetDay(date, interval)
+	count := &timetable.FleetCount{Name: "all routes", Peak: day.Peak, PeakAt: day.PeakAt, Minutes: day.Minutes}
+	if routeID := query.Get("route"); routeID != "" {
+		if scheduler.Feed.Routes[routeID] == nil {
+			return &notFoundError{"route", routeID}
+		}
+		count = &timetable.FleetCount{Name: "route " + routeID, Minutes: make([]int, len(day.Minutes))}
+		for _, route := range day.Routes {
+			if route.Name == routeID {
+				count.Peak, count.PeakAt, count.Minutes = route.Peak, route.PeakAt, route.Minutes
+			}
+		}
+	}
 
 	p, err := plot.New()
 	if err != nil {
 		return errors.Wrap(err, "could not create plot")
 	}
-	p.Add(sc, l, g)
-	heading := fmt.Sprintf("Trip %s profile", "123")
-	p.Title.Text = heading
-	p.Y.Label.Text = "mins"
-	p.X.Label.Text = "Trip stage (mins)"
-	p.X.Min = 0
-	p.X.Max = 60
-	p.Y.Min = 0
-	p.Y.Max = 30
-
-	wt, err := p.WriterTo(512, 100, "png")
+	hours := interval.Hours()
+	histogram := &plotter.Histogram{Width: hours, FillColor: color.NRGBA{B: 200, A: 64}}
+	histogram.LineStyle.Width = 0
+	for band, vehicles := range timetable.FleetHistogram(count.Minutes, interval) {
+		min := float64(band) * hours
+		histogram.Bins = append(histogram.Bins, plotter.HistogramBin{Min: min, Max: min + hours, Weight: float64(vehicles)})
+	}
+	minutes := make(plotter.XYs, len(count.Minutes))
+	for minute, vehicles := range count.Minutes {
+		minutes[minute].X, minutes[minute].Y = float64(minute)/60, float64(vehicles)
+	}
+	inService, err := plotter.NewLine(minutes)
+	if err != nil {
+		return errors.Wrap(err, "could not create vehicles in service")
+	}
+	p.Add(plotter.NewGrid(), inService)
+	if len(histogram.Bins) > 0 {
+		p.Add(histogram)
+		p.Legend.Add(fmt.Sprintf("most in %s", interval), histogram)
+	}
+	p.Legend.Add("in service", inService)
+	p.Title.Text = fmt.Sprintf("Vehicles %s %s", count.Name, timetable.Datestamp(date))
+	if count.Peak > 0 {
+		p.Title.Text += fmt.Sprintf(", peak %d at %s", count.Peak, count.PeakAt)
+	}
+	p.X.Label.Text = "Hours from the start of the service day"
+	p.Y.Label.Text = "Vehicles"
+	p.X.Min, p.X.Max, p.Y.Min = 0, float64(len(count.Minutes))/60, 0
+	p.Legend.Top = true
+
+	wt, err := p.WriterTo(768, 288, "png")
 	if err != nil {
 		return errors.Wrap(err, "could not create writer to")
 	}
+	w.Header().Set("Content-Type", "image/png")
+	_, err = wt.WriteTo(w)
+	return errors.Wrap(err, "could not write to output")
+}
+
+// headways - /statz/headways.png plots the headway of each departure at the stop ?stop=code on ?date=YYYY-MM-DD
+// (today by default), of all routes or only of ?route=ID, over the average headway of each time band of ?bands=. The
+// shaded area is the frequent network, headways up to ?frequent= (default 15m) through ?window= (default 07:00-19:00).
+// Without ?stop= it plots the stop with the most stop times on the date
+func (s *server) headways(w http.ResponseWriter, r *http.Request) error {
+	s.RLock()
+	defer s.RUnlock()
+	query := r.URL.Query()
+	scheduler, err := s.feed(query.Get("agency"))
+	if err != nil {
+		return err
+	}
+	date := timetable.Today(scheduler.Location())
+	if text := query.Get("date"); text != "" {
+		if date, err = timetable.ParseDate(text); err != nil {
+			return badRequestError("date: " + err.Error())
+		}
+	}
+	routeID := query.Get("route")
+	if routeID != "" && scheduler.Feed.Routes[routeID] == nil {
+		return &notFoundError{"route", routeID}
+	}
+	bands, _ := timetable.ParseTimeBands(timetable.DefaultHeadwayBands)
+	if text := query.Get("bands"); text != "" {
+		if bands, err = timetable.ParseTimeBands(text); err != nil {
+			return badRequestError("bands: " + err.Error())
+		}
+	}
+	frequent := timetable.DefaultFrequentHeadway
+	if text := query.Get("frequent"); text != "" {
+		if frequent, err = time.ParseDuration(text); err != nil || frequent <= 0 {
+			return badRequestError(fmt.Sprintf("frequent: invalid duration %q", text))
+		}
+	}
+	window, _ := timetable.ParseTimeBand(timetable.DefaultFrequentWindow)
+	if text := query.Get("window"); text != "" {
+		if window, err = timetable.ParseTimeBand(text); err != nil {
+			return badRequestError("window: " + err.Error())
+		}
+	}
+	var stop *gtfs.Stop
+	if stopCode := query.Get("stop"); stopCode != "" {
+		if stop = scheduler.FindStop(stopCode); stop == nil {
+			return &notFoundError{"stop", stopCode}
+		}
+	} else if stop = scheduler.BusiestStop(date, routeID); stop == nil {
+		return &notFoundError{"stop", "with departures on " + timetable.Datestamp(date)}
+	}
+	headways, departures := scheduler.StopHeadways(stop, date, routeID, bands, frequent, window)
+
+	p, err := plot.New()
+	if err != nil {
+		return errors.Wrap(err, "could not create plot")
+	}
+	start, end := float64(window.Start)/3600, float64(window.End)/3600
+	area := plotter.XYs{{X: start, Y: 0}, {X: start, Y: frequent.Minutes()}, {X: end, Y: frequent.Minutes()}, {X: end, Y: 0}}
+	frequentArea, err := plotter.NewPolygon(area)
+	if err != nil {
+		return errors.Wrap(err, "could not create frequent network")
+	}
+	frequentArea.Color = color.NRGBA{G: 200, A: 64}
+	frequentArea.LineStyle.Width = 0
+	p.Add(plotter.NewGrid(), frequentArea)
+	p.Legend.Add(fmt.Sprintf("every %g min %s", frequent.Minutes(), window), frequentArea)
+	averages := &plotter.Histogram{FillColor: color.NRGBA{B: 200, A: 64}}
+	averages.LineStyle.Width = 0
+	for i, band := range headways.Bands {
+		if band.Average > 0 {
+			averages.Bins = append(averages.Bins, plotter.HistogramBin{Min: float64(bands[i].Start) / 3600,
+				Max: float64(bands[i].End) / 3600, Weight: float64(band.Average) / 60})
+		}
+	}
+	if len(averages.Bins) > 0 {
+		p.Add(averages)
+		p.Legend.Add("band average", averages)
+	}
+	var gaps plotter.XYs
+	for i := 1; i < len(departures); i++ {
+		gaps = append(gaps, plotter.XY{X: float64(departures[i]) / 3600, Y: float64(departures[i]-departures[i-1]) / 60})
+	}
+	if len(gaps) > 0 {
+		line, points, err := plotter.NewLinePoints(gaps)
+		if err != nil {
+			return errors.Wrap(err, "could not create headways")
+		}
+		points.Shape = draw.CircleGlyph{}
+		p.Add(line, points)
+		p.Legend.Add("headway", line, points)
+	}
+	p.Title.Text = fmt.Sprintf("Headways at %s %s", stop.Name, headways.Date)
+	if routeID != "" {
+		p.Title.Text += ", route " + routeID
+	}
+	if headways.Frequent {
+		p.Title.Text += ", frequent"
+	}
+	p.X.Label.Text = "Hours from the start of the day"
+	p.Y.Label.Text = "Minutes since the previous departure"
+	p.X.Min, p.X.Max, p.Y.Min = start, end, 0
+	for _, band := range bands {
+		p.X.Min, p.X.Max = math.Min(p.X.Min, float64(band.Start)/3600), math.Max(p.X.Max, float64(band.End)/3600)
+	}
+	if len(departures) > 0 {
+		p.X.Min = math.Min(p.X.Min, float64(departures[0])/3600)
+		p.X.Max = math.Max(p.X.Max, float64(departures[len(departures)-1])/3600)
+	}
+	p.Legend.Top = true
 
+	wt, err := p.WriterTo(768, 288, "png")
+	if err != nil {
+		return errors.Wrap(err, "could not create writer to")
+	}
+	w.Header().Set("Content-Type", "image/png")
 	_, err = wt.WriteTo(w)
 	return errors.Wrap(err, "could not write to output")
 }
 
+// gtfsMinutes - the minutes of a GTFS time from the start of its service day
+func gtfsMinutes(t gtfs.Time) float64 {
+	return float64(t.Hour)*60 + float64(t.Minute) + float64(t.Second)/60
+}
+
+// root - / lists the API paths, or answers ?trip=, ?stop=, ?agency= or ?authority= with JSON
 func (s *server) root(w http.ResponseWriter, r *http.Request) (err error) {
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
 		return err
 	}
 
-	query := r.URL.RawQuery
+	// /?agency=key makes the feed of the agency active like /statz/setAgency, which takes the lock itself
+	query := r.URL.Query()
+	if query.Get("agency") != "" && query.Get("trip") == "" && query.Get("stop") == "" {
+		if err = s.switchFeed(query.Get("agency")); err != nil {
+			return err
+		}
+	}
+	s.RLock()
+	defer s.RUnlock()
 	var data []byte
-	// request := r.RequestURI
-	switch words := strings.Split(query, "="); strings.ToLower(words[0]) {
-	case "agency":
-		data, err = SetAgency(s, words[1])
-	case "authority":
-		data, err = SetAuthority(s, words[1])
-	case "trip":
-		data, err = GetTripJSON(s, words[1])
-	case "stop":
-		data, err = GetStopJSON(s, words[1])
+	switch {
+	case query.Get("trip") != "" || query.Get("stop") != "":
+		var scheduler *timetable.Scheduler
+		if scheduler, err = s.feed(query.Get("agency")); err != nil {
+			break
+		}
+		if query.Get("trip") != "" {
+			data, err = GetTripJSON(scheduler, query.Get("trip"))
+		} else {
+			data, err = GetStopJSON(scheduler, query.Get("stop"))
+		}
+	case query.Get("agency") != "":
+		data, err = json.Marshal(s.status())
+	case query.Get("authority") != "":
+		data, err = SetAuthority(s, query.Get("authority"))
+	default:
+		data, err = json.Marshal(apiPaths())
 	}
 	if err != nil {
-		fmt.Println(err.Error())
 		return err
 	}
-	jsonData := string(data)
-	fmt.Fprintf(w, "%s", jsonData)
-
-	// x := 500 + 200*rand.NormFloat64()
-	// d := time.Duration(x) * time.Millisecond
-	// // time.Sleep(d)
-	// fmt.Fprintln(w, "slept for", d)
-
-	// s.Lock()
-	// s.data = append(s.data, d)
-	// if len(s.data) > 1000 {
-	// 	s.data = s.data[len(s.data)-1000:]
-	// }
-	// s.Unlock()
+	w.Header().Set("Content-Type", jsonContentType)
+	_, err = w.Write(data)
 	return err
 }
 
-
 // SetAuthority -
 func SetAuthority(s *server, authority string) (data []byte, err error) {
 	if len(authority) == 0 {
 		data, err = json.Marshal(s.authorities)
 	} else {
-		data, err = 
+		data, err = json.Marshal(s.authorities[authority])
 	}
 	return data, err
 }
 
-// SetAgency -
-func SetAgency(s *server, agency string) (data []byte, err error) {
-	data, err = json.Marshal(s.agencies)
-	return data, err
+// GetTripJSON - the trip with the specified trip_id and its stop times
+func GetTripJSON(scheduler *timetable.Scheduler, tripID string) (data []byte, err error) {
+	tripDetail := scheduler.FindTripDetail(tripID)
+	if tripDetail == nil {
+		return nil, &notFoundError{"trip", tripID}
+	}
+	return json.Marshal(tripDetail)
 }
 
-// GetTripJSON -
-func GetTripJSON(s *server, tripID string) (data []byte, err error) {
-	return data, err
+// GetStopJSON - the stop with the specified stop_code and the routes calling at it
+func GetStopJSON(scheduler *timetable.Scheduler, stopCode string) (data []byte, err error) {
+	stopDetail := scheduler.FindStopDetail(stopCode)
+	if stopDetail == nil {
+		return nil, &notFoundError{"stop", stopCode}
+	}
+	return json.Marshal(stopDetail)
 }
 
-// GetStopJSON -
-func GetStopJSON(s *server, stopCode string) (data []byte, err error) {
-	return data, err
-}
\ No newline at end of file
+// GetStopPointsForTripJSON - the stops of the trip, or only its timing points, in stop_sequence order
+func GetStopPointsForTripJSON(scheduler *timetable.Scheduler, tripID string, timingPoint bool) (data []byte, err error) {
+	if scheduler.FindTrip(tripID, "") == nil {
+		return nil, &notFoundError{"trip", tripID}
+	}
+	stopPoints := scheduler.FindStopPointsForTrip(tripID, timingPoint)
+	if stopPoints == nil {
+		stopPoints = []*timetable.StopPoint{}
+	}
+	return json.Marshal(stopPoints)
+}
+
+// defaultDepartures - the number of departures returned when the request has no limit
+const defaultDepartures = 10
+
+// stopDepartures - the next departures at the stop with the stop_code, after ?time=HH:MM[:SS] on ?date=YYYY-MM-DD
+// (now by default), at most ?limit=N and only of ?route=ID if given
+func stopDepartures(scheduler *timetable.Scheduler, stopCode string, query url.Values) (departures []*timetable.Departure, err error) {
+	stop := scheduler.FindStop(stopCode)
+	if stop == nil {
+		return nil, &notFoundError{"stop", stopCode}
+	}
+	now := time.Now().In(scheduler.Location())
+	departureQuery := timetable.DepartureQuery{
+		Date:    timetable.ToDate(now.Date()),
+		After:   gtfs.Time{Hour: int8(now.Hour()), Minute: int8(now.Minute()), Second: int8(now.Second())},
+		Limit:   defaultDepartures,
+		RouteID: query.Get("route"),
+	}
+	if date := query.Get("date"); date != "" {
+		if departureQuery.Date, err = timetable.ParseDate(date); err != nil {
+			return nil, badRequestError("date: " + err.Error())
+		}
+		departureQuery.After = gtfs.Time{}
+	}
+	if after := query.Get("time"); after != "" {
+		if departureQuery.After, err = timetable.ParseTime(after); err != nil {
+			return nil, badRequestError("time: " + err.Error())
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if departureQuery.Limit, err = strconv.Atoi(limit); err != nil || departureQuery.Limit < 1 {
+			return nil, badRequestError(fmt.Sprintf("limit: invalid number of departures %q", limit))
+		}
+	}
+	if departureQuery.RouteID != "" && scheduler.Feed.Routes[departureQuery.RouteID] == nil {
+		return nil, &notFoundError{"route", departureQuery.RouteID}
+	}
+	departures = scheduler.Departures([]*gtfs.Stop{stop}, departureQuery)
+	if departures == nil {
+		departures = []*timetable.Departure{}
+	}
+	return departures, nil
+}
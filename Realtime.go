@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	gtfsrt "github.com/thingful/transit_realtime"
+	"transitrhythm.com/gtfs-parse/timetable"
+)
+
+// defaultRealtimeInterval - how often the GTFS-RT feeds are read, unless configured
+const defaultRealtimeInterval = 30 * time.Second
+
+// vehicleStateExpiry - how long the state of a vehicle on a trip is kept after its last VehiclePosition
+const vehicleStateExpiry = 2 * time.Hour
+
+// realtimeFeeds - the GTFS-RT feeds the server polls, each a file or an http:// URL
+type realtimeFeeds struct {
+	tripUpdates       string
+	vehiclePositions  string
+	interval          time.Duration
+	passengerCapacity int // passengers per vehicle, from which the loads are estimated, or 0 if unknown
+}
+
+// tripUpdatesStatus - the outcome of the last read of the TripUpdates feed, the JSON response of /statz/tripUpdates
+type tripUpdatesStatus struct {
+	Source    string
+	Timestamp time.Time // when the feed was read
+	Matched   int
+	Unmatched int
+	Error     string `json:",omitempty"`
+	TripID    string // trip whose delays make up the adherence series
+	Trips     []*timetable.TripDelays
+}
+
+// vehiclePositionsStatus - the outcome of the last read of the VehiclePositions feed and the state table of the
+// vehicles, the JSON response of /statz/vehicles
+type vehiclePositionsStatus struct {
+	Source            string
+	Timestamp         time.Time // when the feed was read
+	Error             string    `json:",omitempty"`
+	PassengerCapacity int
+	Vehicles          []*vehicleLoad
+}
+
+// vehicleLoad - the state of a vehicle on a trip and its estimated passenger load, 0 if the capacity is unknown
+type vehicleLoad struct {
+	*timetable.VehicleState
+	PassengerLoad int
+}
+
+// startRealtime - polls each of the configured GTFS-RT feeds in the background
+func (s *server) startRealtime(realtime realtimeFeeds) {
+	s.passengerCapacity = realtime.passengerCapacity
+	s.vehiclePositions.PassengerCapacity = realtime.passengerCapacity
+	s.vehiclePositions.Vehicles = []*vehicleLoad{}
+	if realtime.tripUpdates != "" {
+		go poll("TripUpdates", realtime.tripUpdates, realtime.interval, s.readTripUpdates)
+	}
+	if realtime.vehiclePositions != "" {
+		go poll("VehiclePositions", realtime.vehiclePositions, realtime.interval, s.readVehiclePositions)
+	}
+}
+
+// poll - reads the feed every interval for as long as the server runs
+func poll(name, source string, interval time.Duration, read func(source string) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := read(source); err != nil {
+			log.Println(name+":", err)
+		}
+		<-ticker.C
+	}
+}
+
+// readTripUpdates - reads the TripUpdates feed and matches it against the active feed. The feed is read and matched
+// without holding the lock, and the result is dropped if the active feed changed meanwhile
+func (s *server) readTripUpdates(source string) error {
+	s.RLock()
+	scheduler := s.scheduler
+	s.RUnlock()
+	status := tripUpdatesStatus{Source: source, Timestamp: time.Now(), Trips: []*timetable.TripDelays{}}
+	message, err := timetable.ReadFeedMessage(source)
+	if err == nil && scheduler != nil {
+		status.Trips, status.Unmatched = scheduler.MatchTripUpdates(message)
+		status.Matched = len(status.Trips)
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.scheduler != scheduler {
+		return nil
+	}
+	s.tripUpdates = status
+	if err == nil {
+		s.tripDelays = make(map[string]*timetable.TripDelays)
+		for _, tripDelays := range status.Trips {
+			s.tripDelays[tripDelays.TripID] = tripDelays
+		}
+		s.trackTrip(s.tripID)
+	}
+	return err
+}
+
+// trackTrip - makes the delays of the trip the adherence series, or those of the first trip to start if the trip has
+// no TripUpdate. The caller holds the lock
+func (s *server) trackTrip(tripID string) {
+	tripDelays := s.tripDelays[tripID]
+	for _, t := range s.tripUpdates.Trips {
+		if tripDelays == nil && !t.Canceled && len(t.Stops) > 0 {
+			tripDelays = t
+		}
+	}
+	s.data = nil
+	if tripDelays == nil {
+		s.tripID, s.routeID, s.vehicleID, s.tripStart = "", "", "", time.Time{}
+		s.tripUpdates.TripID = ""
+		s.passengerLoad = 0
+		return
+	}
+	s.tripID = tripDelays.TripID
+	s.routeID = tripDelays.RouteID
+	s.vehicleID = tripDelays.VehicleID
+	s.tripStart = tripDelays.Start
+	for _, stop := range tripDelays.Stops {
+		s.data = append(s.data, time.Duration(stop.Delay)*time.Second)
+	}
+	s.tripUpdates.TripID = s.tripID
+	s.trackLoad()
+}
+
+// getTripUpdates - /statz/tripUpdates is the last read of the TripUpdates feed, or /statz/tripUpdates?trip=ID the
+// delays of a single trip
+func (s *server) getTripUpdates(w http.ResponseWriter, r *http.Request) error {
+	s.RLock()
+	defer s.RUnlock()
+	if tripID := r.URL.Query().Get("trip"); tripID != "" {
+		tripDelays := s.tripDelays[tripID]
+		if tripDelays == nil {
+			return &notFoundError{"TripUpdate for trip", tripID}
+		}
+		return writeJSON(w, http.StatusOK, tripDelays)
+	}
+	return writeJSON(w, http.StatusOK, s.tripUpdates)
+}
+
+// setTrip - /statz/setTrip?trip=ID makes the delays of the trip the adherence series plotted by /statz/trip.png
+func (s *server) setTrip(w http.ResponseWriter, r *http.Request) error {
+	s.Lock()
+	defer s.Unlock()
+	tripID := r.URL.Query().Get("trip")
+	if s.tripDelays[tripID] == nil {
+		return &notFoundError{"TripUpdate for trip", tripID}
+	}
+	s.trackTrip(tripID)
+	return writeJSON(w, http.StatusOK, s.tripDelays[tripID])
+}
+
+// readVehiclePositions - reads the VehiclePositions feed and matches it against the active feed, updating the state
+// table of the vehicles. States not updated for vehicleStateExpiry are dropped
+func (s *server) readVehiclePositions(source string) error {
+	s.RLock()
+	scheduler := s.scheduler
+	s.RUnlock()
+	message, err := timetable.ReadFeedMessage(source)
+	var vehicles []*timetable.VehicleState
+	if err == nil && scheduler != nil {
+		vehicles = scheduler.MatchVehiclePositions(message)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.scheduler != scheduler {
+		return nil
+	}
+	s.vehiclePositions.Source = source
+	s.vehiclePositions.Timestamp = time.Now()
+	s.vehiclePositions.Error = ""
+	if err != nil {
+		s.vehiclePositions.Error = err.Error()
+		return err
+	}
+	if s.vehicles == nil {
+		s.vehicles = make(map[string]*vehicleLoad)
+	}
+	for _, vehicle := range vehicles {
+		s.vehicles[vehicle.Key()] = &vehicleLoad{vehicle, s.estimateLoad(vehicle)}
+	}
+	s.vehiclePositions.Vehicles = []*vehicleLoad{}
+	for key, vehicle := range s.vehicles {
+		if s.vehiclePositions.Timestamp.Sub(vehicle.Timestamp) > vehicleStateExpiry {
+			delete(s.vehicles, key)
+			continue
+		}
+		s.vehiclePositions.Vehicles = append(s.vehiclePositions.Vehicles, vehicle)
+	}
+	sort.Slice(s.vehiclePositions.Vehicles, func(i, j int) bool {
+		a, b := s.vehiclePositions.Vehicles[i], s.vehiclePositions.Vehicles[j]
+		if a.BlockID != b.BlockID {
+			return a.BlockID < b.BlockID
+		}
+		return a.Key() < b.Key()
+	})
+	s.trackLoad()
+	return nil
+}
+
+// estimateLoad - the passengers on the vehicle from its occupancy percentage and the passenger capacity
+func (s *server) estimateLoad(vehicle *timetable.VehicleState) int {
+	if s.passengerCapacity == 0 || vehicle.OccupancyPercentage < 0 {
+		return 0
+	}
+	return (vehicle.OccupancyPercentage*s.passengerCapacity + 50) / 100
+}
+
+// trackLoad - takes the passenger load of the vehicle on the tracked trip, finding the vehicle from the
+// VehiclePositions if the TripUpdate did not name it. The caller holds the lock
+func (s *server) trackLoad() {
+	s.passengerLoad = 0
+	for _, vehicle := range s.vehiclePositions.Vehicles {
+		if s.tripID != "" && vehicle.TripID == s.tripID && (s.vehicleID == "" || vehicle.VehicleID == s.vehicleID) {
+			s.vehicleID = vehicle.VehicleID
+			s.passengerLoad = vehicle.PassengerLoad
+		}
+	}
+}
+
+// getVehicles - /statz/vehicles is the state table of the vehicles, restricted to ?block=ID, ?trip=ID or
+// ?vehicle=ID if given
+func (s *server) getVehicles(w http.ResponseWriter, r *http.Request) error {
+	s.RLock()
+	defer s.RUnlock()
+	query := r.URL.Query()
+	status := s.vehiclePositions
+	status.Vehicles = []*vehicleLoad{}
+	for _, vehicle := range s.vehiclePositions.Vehicles {
+		if (query.Get("block") == "" || vehicle.BlockID == query.Get("block")) &&
+			(query.Get("trip") == "" || vehicle.TripID == query.Get("trip")) &&
+			(query.Get("vehicle") == "" || vehicle.VehicleID == query.Get("vehicle")) {
+			status.Vehicles = append(status.Vehicles, vehicle)
+		}
+	}
+	return writeJSON(w, http.StatusOK, status)
+}
+
+// vehicleTable - the vehicle state table of the statz page, by block. The caller holds the read lock
+func (s *server) vehicleTable() string {
+	var rows []string
+	loc := time.Local
+	if s.scheduler != nil {
+		loc = s.scheduler.Location()
+	}
+	for _, vehicle := range s.vehiclePositions.Vehicles {
+		occupancy := vehicle.OccupancyStatus
+		if vehicle.OccupancyPercentage >= 0 {
+			estimated := ""
+			if vehicle.OccupancyEstimated {
+				estimated = "~"
+			}
+			occupancy = strings.TrimSpace(fmt.Sprintf("%s %s%d%%", occupancy, estimated, vehicle.OccupancyPercentage))
+		}
+		load := ""
+		if s.passengerCapacity > 0 && vehicle.OccupancyPercentage >= 0 {
+			load = fmt.Sprintf("%d / %d", vehicle.PassengerLoad, s.passengerCapacity)
+		}
+		cells := []string{vehicle.BlockID, vehicle.RouteID, vehicle.TripID, vehicle.VehicleID, vehicle.CurrentStatus,
+			vehicle.StopID, occupancy, load, vehicle.Timestamp.In(loc).Format("15:04:05")}
+		for i, cell := range cells {
+			cells[i] = html.EscapeString(cell)
+		}
+		rows = append(rows, "<tr><td>"+strings.Join(cells, "</td><td>")+"</td></tr>")
+	}
+	return fmt.Sprintf(HTMLVehicles, strings.Join(rows, "\n\t"))
+}
+
+// simulatorPrefix - the path of the GTFS-RT feeds simulated from the schedule of the active feed
+const simulatorPrefix = "/gtfs-rt/"
+
+// simulatedFeeds - the GTFS-RT feed of each simulator path, served as {name}.pb or {name}.json
+var simulatedFeeds = map[string]func(scheduler *timetable.Scheduler, at time.Time, delay time.Duration) *gtfsrt.FeedMessage{
+	"tripupdates":      (*timetable.Scheduler).SimulateTripUpdates,
+	"vehiclepositions": (*timetable.Scheduler).SimulateVehiclePositions,
+}
+
+// simulator - /gtfs-rt/tripupdates.pb and /gtfs-rt/vehiclepositions.pb are GTFS-RT feeds of the trips running at
+// ?date=YYYY-MM-DD&time=HH:MM[:SS] in the agency time zone (now by default), all late by ?delay=seconds. The .json
+// paths serve the same feeds as JSON
+func (s *server) simulator(w http.ResponseWriter, r *http.Request) error {
+	name := strings.TrimPrefix(r.URL.Path, simulatorPrefix)
+	format := path.Ext(name)
+	simulate, ok := simulatedFeeds[strings.TrimSuffix(name, format)]
+	if !ok || (format != ".pb" && format != ".json") {
+		return &notFoundError{"GTFS-RT feed", name}
+	}
+	s.RLock()
+	defer s.RUnlock()
+	query := r.URL.Query()
+	scheduler, err := s.feed(query.Get("agency"))
+	if err != nil {
+		return err
+	}
+	at, err := simulatedTime(scheduler, query)
+	if err != nil {
+		return err
+	}
+	var delay int
+	if text := query.Get("delay"); text != "" {
+		if delay, err = strconv.Atoi(text); err != nil {
+			return badRequestError(fmt.Sprintf("delay: invalid number of seconds %q", text))
+		}
+	}
+	message := simulate(scheduler, at, time.Duration(delay)*time.Second)
+	if format == ".json" {
+		data, err := (&jsonpb.Marshaler{OrigName: true}).MarshalToString(message)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", jsonContentType)
+		_, err = io.WriteString(w, data)
+		return err
+	}
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, err = w.Write(data)
+	return err
+}
+
+// simulatedTime - the instant of the ?date= and ?time= of the query in the agency time zone, defaulting to now and to
+// 00:00 on a date without a time
+func simulatedTime(scheduler *timetable.Scheduler, query url.Values) (time.Time, error) {
+	loc := scheduler.Location()
+	now := time.Now().In(loc)
+	date := timetable.ToDate(now.Date())
+	clock := gtfs.Time{Hour: int8(now.Hour()), Minute: int8(now.Minute()), Second: int8(now.Second())}
+	var err error
+	if text := query.Get("date"); text != "" {
+		if date, err = timetable.ParseDate(text); err != nil {
+			return now, badRequestError("date: " + err.Error())
+		}
+		clock = gtfs.Time{}
+	}
+	if text := query.Get("time"); text != "" {
+		if clock, err = timetable.ParseTime(text); err != nil {
+			return now, badRequestError("time: " + err.Error())
+		}
+	}
+	return time.Date(int(date.Year), time.Month(date.Month), int(date.Day), int(clock.Hour), int(clock.Minute), int(clock.Second), 0, loc), nil
+}
@@ -4,9 +4,10 @@ go 1.13
 
 require (
 	github.com/geops/gtfsparser v0.0.0-20180817212205-1cc2f4676115
-	github.com/golang/protobuf v1.3.2 // indirect
+	github.com/golang/protobuf v1.3.2
 	github.com/patrickbr/gtfsparser v0.0.0-20190626145000-1a9ecb04a337
 	github.com/pkg/errors v0.8.1
-	github.com/thingful/transit_realtime v0.0.0-20160302010101-10b58115df9e // indirect
+	github.com/thingful/transit_realtime v0.0.0-20160302010101-10b58115df9e
 	gonum.org/v1/plot v0.0.0-20191004082913-159cd04f920c
+	gopkg.in/yaml.v2 v2.4.0
 )
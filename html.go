@@ -36,7 +36,7 @@ setInterval(function() {
 </body>
 </html>`
 
-// HTMLDropdown
+// HTMLDropdown - the authority & agency drop-down lists, formatted with the options of each
 var HTMLDropdown = `
 	<style>
 	.grid1 {
@@ -59,10 +59,9 @@ var HTMLDropdown = `
 	<div id="authority">
 	<h2>Transit Authority</h2>
 	<p><i>Change the authority using the drop-down list:</i></p>	
-	<form action="/authority_page.php">
+	<form action="/statz/setAuthority">
 	  <select name="authority">
-	  <option value="1">BC Transit</option>
-	  <option value="2">Vancouver Translink</option>
+		%s
 	  </select>
 	  <br><br>
 	  <input type="submit">
@@ -70,18 +69,12 @@ var HTMLDropdown = `
 	</div>
 	</th>
 	<th>
-	<div id="agency" class="hidden">
+	<div id="agency">
 	<h2>Transit Agency</h2>
 	<p><i>Change the transit agency using the drop-down list:</i></p>	
-	<form action="/agency_page.php">
+	<form action="/statz/setAgency">
 	  <select name="agency">
-		<option value="12">Comox Valley Transit System</option>
-		<option value="8">Kamloops Transit System</option>
-		<option value="7">Kelowna Regional Transit System</option>
-		<option value="5">RDN Transit System</option>
-		<option value="4">Squamish Transit System</option>
-		<option value="1">Victoria Regional Transit System</option>
-		<option value="3">Whistler Transit System</option>
+		%s
 	  </select>
 	  <br><br>
 	  <input type="submit">
@@ -91,3 +84,11 @@ var HTMLDropdown = `
 	</th>
 	</tr>
 	</table>`
+
+// HTMLVehicles - the vehicle state table of the statz page, formatted with a row for each vehicle
+var HTMLVehicles = `
+	<h2>Vehicles</h2>
+	<table>
+	<tr><th>Block</th><th>Route</th><th>Trip</th><th>Vehicle</th><th>Status</th><th>Stop</th><th>Occupancy</th><th>Load</th><th>Updated</th></tr>
+	%s
+	</table>`
@@ -0,0 +1,236 @@
+package timetable
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DefaultMinLayover - the shortest layover between two trips of a block that is not reported as too short
+const DefaultMinLayover = 5 * time.Minute
+
+// Checks of the block feasibility report
+const (
+	BlockOverlap          = "overlap"           // the next trip departs before the trip arrives
+	BlockShortLayover     = "short_layover"     // the layover between the trips is shorter than the minimum
+	BlockTerminalMismatch = "terminal_mismatch" // the next trip starts at another stop, an implied deadhead
+	blockFeasible         = "ok"
+)
+
+// BlockIssue - a problem with the chaining of a trip of a block to the next trip it operates on the service day
+type BlockIssue struct {
+	Check      string
+	TripID     string
+	Arrival    string // GTFS time the trip arrives at its last stop
+	NextTripID string
+	Departure  string // GTFS time the next trip departs from its first stop
+	Layover    int    // seconds between the arrival & the departure, negative when the trips overlap
+	LastStop   string
+	FirstStop  string
+	Distance   float64 `json:",omitempty"` // straight line km of the implied deadhead between the two stops
+	Message    string
+}
+
+// BlockFeasibilityDay - the chaining problems of a block on a service day
+type BlockFeasibilityDay struct {
+	BlockID            string
+	Date               string
+	Trips              int
+	Overlaps           int
+	ShortLayovers      int
+	TerminalMismatches int
+	Issues             []*BlockIssue
+}
+
+// BlockFeasibility - the chaining problems of blocks on each of their service days
+type BlockFeasibility struct {
+	MinLayover int // seconds
+	Days       []*BlockFeasibilityDay
+}
+
+// add - records the issue against the day
+func (day *BlockFeasibilityDay) add(issue *BlockIssue) {
+	day.Issues = append(day.Issues, issue)
+	switch issue.Check {
+	case BlockOverlap:
+		day.Overlaps++
+	case BlockShortLayover:
+		day.ShortLayovers++
+	case BlockTerminalMismatch:
+		day.TerminalMismatches++
+	}
+}
+
+// sameTerminal - the stops are the same stop or platforms of the same station
+func sameTerminal(a, b *gtfs.Stop) bool {
+	if a == b {
+		return true
+	}
+	return a.Parent_station != nil && b.Parent_station != nil && a.Parent_station == b.Parent_station
+}
+
+// CheckBlockSchedule - checks that the trips of each day of a block schedule, as built by CreateWeekSchedule or
+// CreateBlockScheduleRange, follow one another: each trip must arrive before the next one departs, with at least
+// the minimum layover, at the stop the next trip starts from. Each trip is compared with the trip ending latest
+// before it, so that a long trip overlapping several others is reported against each of them
+func (s *Scheduler) CheckBlockSchedule(blockSchedule BlockSchedule, minLayover time.Duration) (days []*BlockFeasibilityDay) {
+	SortBlockSchedule(blockSchedule)
+	for _, blockDay := range blockSchedule.BlockDays {
+		for _, block := range blockDay.Blocks {
+			day := &BlockFeasibilityDay{BlockID: block.BlockID, Date: Datestamp(blockDay.Date), Issues: []*BlockIssue{}}
+			var previous *gtfs.Trip
+			for _, trip := range block.Trips {
+				if len(trip.StopTimes) == 0 {
+					continue
+				}
+				day.Trips++
+				// Each trip generated from frequencies.txt has a vehicle of its own, so it does not follow the others
+				if s.FrequencyOf(trip) != nil {
+					continue
+				}
+				if previous != nil {
+					s.checkLayover(day, previous, trip, minLayover)
+				}
+				if previous == nil || endSeconds(trip) > endSeconds(previous) {
+					previous = trip
+				}
+			}
+			if len(day.Issues) > 0 {
+				log.Printf("Block %s %s: %d trips, %d overlaps, %d short layovers, %d terminal mismatches\n", day.BlockID,
+					day.Date, day.Trips, day.Overlaps, day.ShortLayovers, day.TerminalMismatches)
+			}
+			days = append(days, day)
+		}
+	}
+	sort.SliceStable(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+	return days
+}
+
+// checkLayover - compares the arrival of a trip at its last stop with the departure of the next trip of the block
+func (s *Scheduler) checkLayover(day *BlockFeasibilityDay, trip, next *gtfs.Trip, minLayover time.Duration) {
+	last := trip.StopTimes[len(trip.StopTimes)-1]
+	first := next.StopTimes[0]
+	issue := BlockIssue{
+		TripID:     trip.Id,
+		Arrival:    GTFSTime(last.Arrival_time),
+		NextTripID: next.Id,
+		Departure:  GTFSTime(first.Departure_time),
+		Layover:    startSeconds(next) - endSeconds(trip),
+		LastStop:   last.Stop.Id,
+		FirstStop:  first.Stop.Id,
+	}
+	layover := time.Duration(issue.Layover) * time.Second
+	switch {
+	case layover < 0:
+		overlap := issue
+		overlap.Check = BlockOverlap
+		overlap.Message = fmt.Sprintf("trip %s departs at %s, %s before trip %s arrives at %s", next.Id,
+			overlap.Departure, -layover, trip.Id, overlap.Arrival)
+		day.add(&overlap)
+	case layover < minLayover:
+		short := issue
+		short.Check = BlockShortLayover
+		short.Message = fmt.Sprintf("layover of %s at %s between trips %s and %s, less than %s", layover,
+			last.Stop.Name, trip.Id, next.Id, minLayover)
+		day.add(&short)
+	}
+	if !sameTerminal(last.Stop, first.Stop) {
+		mismatch := issue
+		mismatch.Check = BlockTerminalMismatch
+		mismatch.Distance = StraightLineDistance(last.Stop.Lat, last.Stop.Lon, first.Stop.Lat, first.Stop.Lon)
+		mismatch.Message = fmt.Sprintf("trip %s ends at %s but trip %s starts at %s, %.1f km away", trip.Id,
+			last.Stop.Name, next.Id, first.Stop.Name, mismatch.Distance)
+		day.add(&mismatch)
+	}
+}
+
+// blockIDs - the IDs of the blocks, in order, leaving out the trips without a block_id
+func (s *Scheduler) blockIDs() (blockIDs []string) {
+	for _, blocktable := range s.Blocktables {
+		if blocktable.BlockID != "" {
+			blockIDs = append(blockIDs, blocktable.BlockID)
+		}
+	}
+	sort.Strings(blockIDs)
+	return blockIDs
+}
+
+// CheckBlocksWeek - checks the week schedule of the block, or of every block when blockID is empty
+func (s *Scheduler) CheckBlocksWeek(blockID string, weekEnding gtfs.Date, minLayover time.Duration) *BlockFeasibility {
+	feasibility := &BlockFeasibility{MinLayover: int(minLayover / time.Second), Days: []*BlockFeasibilityDay{}}
+	for _, id := range s.blockIDs() {
+		if blockID == "" || id == blockID {
+			days := s.CheckBlockSchedule(s.CreateBlockSchedule(id, weekEnding), minLayover)
+			feasibility.Days = append(feasibility.Days, days...)
+		}
+	}
+	return feasibility
+}
+
+// CheckBlocksRange - checks the schedule of the block, or of every block when blockID is empty, for each date from
+// start to end inclusive
+func (s *Scheduler) CheckBlocksRange(blockID string, start, end gtfs.Date, minLayover time.Duration) *BlockFeasibility {
+	feasibility := &BlockFeasibility{MinLayover: int(minLayover / time.Second), Days: []*BlockFeasibilityDay{}}
+	for _, id := range s.blockIDs() {
+		if blockID == "" || id == blockID {
+			days := s.CheckBlockSchedule(s.CreateBlockScheduleRange(id, start, end), minLayover)
+			feasibility.Days = append(feasibility.Days, days...)
+		}
+	}
+	return feasibility
+}
+
+// PrintBlockFeasibilityCSV - writes a row for each issue of each block & service day, and a single "ok" row for the
+// block days without issues
+func (s *Scheduler) PrintBlockFeasibilityCSV(filename string, feasibility *BlockFeasibility) error {
+	file, err := s.createFile(s.reportAgency(), filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"Block", "Date", "Trips", "Check", "Trip ID", "Arrival", "Next Trip ID", "Departure",
+		"Layover (min)", "Last Stop", "First Stop", "Deadhead (km)", "Message"})
+	issues := 0
+	for _, day := range feasibility.Days {
+		trips := strconv.Itoa(day.Trips)
+		if len(day.Issues) == 0 {
+			writer.Write([]string{day.BlockID, day.Date, trips, blockFeasible, "", "", "", "", "", "", "", "", ""})
+			continue
+		}
+		for _, issue := range day.Issues {
+			distance := ""
+			if issue.Check == BlockTerminalMismatch {
+				distance = strconv.FormatFloat(issue.Distance, 'f', 2, 64)
+			}
+			writer.Write([]string{day.BlockID, day.Date, trips, issue.Check, issue.TripID, issue.Arrival,
+				issue.NextTripID, issue.Departure, strconv.FormatFloat(float64(issue.Layover)/60, 'f', -1, 64),
+				issue.LastStop, issue.FirstStop, distance, issue.Message})
+			issues++
+		}
+	}
+	writer.Flush()
+	log.Printf("%s: %d block days, %d issues written\n", filename, len(feasibility.Days), issues)
+	return writer.Error()
+}
+
+// PrintBlockFeasibilityJSON - writes the block days and their issues as JSON
+func (s *Scheduler) PrintBlockFeasibilityJSON(filename string, feasibility *BlockFeasibility) error {
+	data, err := json.MarshalIndent(feasibility, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := s.createFile(s.reportAgency(), filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(data)
+	log.Printf("%s: %d block days written\n", filename, len(feasibility.Days))
+	return err
+}
@@ -0,0 +1,65 @@
+package timetable
+
+import (
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"reflect"
+	"testing"
+)
+
+// testTrip - a trip departing from one stop at the departure and arriving at another at the arrival
+func testTrip(id string, from *gtfs.Stop, departure gtfs.Time, to *gtfs.Stop, arrival gtfs.Time) *gtfs.Trip {
+	return &gtfs.Trip{Id: id, StopTimes: gtfs.StopTimes{
+		{Stop: from, Arrival_time: departure, Departure_time: departure, Sequence: 1},
+		{Stop: to, Arrival_time: arrival, Departure_time: arrival, Sequence: 2},
+	}}
+}
+
+func TestCheckLayover(t *testing.T) {
+	station := &gtfs.Stop{Id: "STN", Name: "Station"}
+	a := &gtfs.Stop{Id: "A", Name: "Terminal A", Lat: 36.0, Lon: -116.0}
+	b := &gtfs.Stop{Id: "B", Name: "Terminal B", Lat: 36.1, Lon: -116.0}
+	platform1 := &gtfs.Stop{Id: "P1", Name: "Platform 1", Parent_station: station}
+	platform2 := &gtfs.Stop{Id: "P2", Name: "Platform 2", Parent_station: station}
+	trip := testTrip("T1", b, gtfs.Time{Hour: 7}, a, gtfs.Time{Hour: 8})
+	tests := []struct {
+		name    string
+		trip    *gtfs.Trip
+		next    *gtfs.Trip
+		checks  []string
+		layover int
+	}{
+		{"long layover", trip, testTrip("T2", a, gtfs.Time{Hour: 8, Minute: 10}, b, gtfs.Time{Hour: 9}), nil, 600},
+		{"minimum layover", trip, testTrip("T2", a, gtfs.Time{Hour: 8, Minute: 5}, b, gtfs.Time{Hour: 9}), nil, 300},
+		{"short layover", trip, testTrip("T2", a, gtfs.Time{Hour: 8, Minute: 4, Second: 59}, b, gtfs.Time{Hour: 9}),
+			[]string{BlockShortLayover}, 299},
+		{"no layover", trip, testTrip("T2", a, gtfs.Time{Hour: 8}, b, gtfs.Time{Hour: 9}), []string{BlockShortLayover}, 0},
+		{"overlap", trip, testTrip("T2", a, gtfs.Time{Hour: 7, Minute: 50}, b, gtfs.Time{Hour: 9}), []string{BlockOverlap}, -600},
+		{"terminal mismatch", trip, testTrip("T2", b, gtfs.Time{Hour: 8, Minute: 30}, a, gtfs.Time{Hour: 9}),
+			[]string{BlockTerminalMismatch}, 1800},
+		{"overlap & terminal mismatch", trip, testTrip("T2", b, gtfs.Time{Hour: 7, Minute: 30}, a, gtfs.Time{Hour: 9}),
+			[]string{BlockOverlap, BlockTerminalMismatch}, -1800},
+		{"platforms of a station", testTrip("T1", a, gtfs.Time{Hour: 7}, platform1, gtfs.Time{Hour: 8}),
+			testTrip("T2", platform2, gtfs.Time{Hour: 8, Minute: 10}, a, gtfs.Time{Hour: 9}), nil, 600},
+		{"past 24:00", testTrip("T1", b, gtfs.Time{Hour: 23, Minute: 30}, a, gtfs.Time{Hour: 24, Minute: 20}),
+			testTrip("T2", a, gtfs.Time{Hour: 24, Minute: 30}, b, gtfs.Time{Hour: 25}), nil, 600},
+	}
+	var s Scheduler
+	for _, test := range tests {
+		day := &BlockFeasibilityDay{BlockID: "B1", Issues: []*BlockIssue{}}
+		s.checkLayover(day, test.trip, test.next, DefaultMinLayover)
+		var checks []string
+		for _, issue := range day.Issues {
+			checks = append(checks, issue.Check)
+			if issue.Layover != test.layover {
+				t.Errorf("%s: %s layover %ds, want %ds", test.name, issue.Check, issue.Layover, test.layover)
+			}
+		}
+		if !reflect.DeepEqual(checks, test.checks) {
+			t.Errorf("%s: got checks %q, want %q", test.name, checks, test.checks)
+		}
+		if day.Overlaps+day.ShortLayovers+day.TerminalMismatches != len(test.checks) {
+			t.Errorf("%s: counted %d overlaps, %d short layovers & %d terminal mismatches, want %d issues", test.name,
+				day.Overlaps, day.ShortLayovers, day.TerminalMismatches, len(test.checks))
+		}
+	}
+}
@@ -1,7 +1,6 @@
-package main
+package timetable
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/patrickbr/gtfsparser"      //"github.com/geops/gtfsparser"
 	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
@@ -18,9 +17,6 @@ type Blocktable struct {
 	Servicetables []*Servicetable
 }
 
-// Blocktables -
-var Blocktables []*Blocktable
-
 // Block -
 type Block struct {
 	BlockID string
@@ -40,32 +36,30 @@ type BlockCalendar struct {
 	BlockDays []*BlockDay
 }
 
+func blockDaysPeriod(blockDays []*BlockDay) (text string) {
+	if len(blockDays) != 0 {
+		text = Period(blockDays[0].Date, blockDays[len(blockDays)-1].Date)
+	}
+	return text
+}
+
 // BlockSchedule -
 type BlockSchedule struct {
 	BlockDays []*BlockDay //	Blocks [7]*Block
 }
 
-// BlockSchedules -
-var BlockSchedules []*BlockSchedule
-
 // BlocktableDay -
 type BlocktableDay Blocktable
 
 // BlocktableWeek -
 type BlocktableWeek [7]*BlocktableDay
 
-// BlocktablesWeek -
-var BlocktablesWeek BlocktableWeek
-
 func addServicetableToBlocktable(blocktable *Blocktable, servicetable *Servicetable) (outputtable *Blocktable) {
 
 	return outputtable
 }
 
 func addTripToBlocktable(blocktables []*Blocktable, trip *gtfs.Trip) (outputtables []*Blocktable) {
-	if trip.Block_id == "51841" {
-		log.Printf("addTripToBlocktable() - Trip ID: %s Block ID: %s Service ID: %s\n", trip.Id, trip.Block_id, trip.Service.Id)
-	}
 	for _, blocktable := range blocktables {
 		if blocktable.BlockID == trip.Block_id {
 			var servicetables []*Servicetable
@@ -167,14 +161,26 @@ func BlockCalendarCSV(feed *gtfsparser.Feed, blocks []*Block, max, index int) (t
 
 const layoutCSV = "January 2006"
 
-func printBlockMonthCSV(feed *gtfsparser.Feed, filename string, blockCalendar *BlockCalendar, monthStarting gtfs.Date) (err error) {
+// PrintBlockMonthCSV -
+func (s *Scheduler) PrintBlockMonthCSV(filename string, blockCalendar *BlockCalendar, monthStarting gtfs.Date) (err error) {
+	return s.printBlockCalendarCSV(filename, blockCalendar, toTime(monthStarting).Format(layoutCSV))
+}
+
+// PrintBlockCalendarCSV - prints a block calendar covering an arbitrary date range
+func (s *Scheduler) PrintBlockCalendarCSV(filename string, blockCalendar *BlockCalendar) (err error) {
+	return s.printBlockCalendarCSV(filename, blockCalendar, blockDaysPeriod(blockCalendar.BlockDays))
+}
+
+func (s *Scheduler) printBlockCalendarCSV(filename string, blockCalendar *BlockCalendar, period string) (err error) {
+	feed := s.Feed
 	for _, feedInfo := range feed.FeedInfos {
 		title := feedInfo.Publisher_name + "\n" + "Version:" + feedInfo.Version + "\n"
-		for _, agency := range feed.Agencies {
-			dayOfWeek := findDaysOfWeekAbbrev(feed, agency)
-			weekday := int(toTime(monthStarting).Weekday())
-			file, err := os.Create(agency.Name + "-" + filename)
-			check(err, "File create error")
+		for _, agency := range s.agencies() {
+			daysOfWeek := s.daysOfWeekAbbrev(agency)
+			file, err := s.createFile(agency, filename)
+			if err != nil {
+				return err
+			}
 			defer file.Close()
 			// Get valid date range
 			start, end := getFeedDateRange(feed, 0)
@@ -183,15 +189,14 @@ func printBlockMonthCSV(feed *gtfsparser.Feed, filename string, blockCalendar *B
 			dayHeaderB := "#,Start,End,"
 			lineA := ""
 			lineB := ""
-			for day := 1; day <= daysThisMonth(); day++ {
-				lineA += fmt.Sprintf(dayHeaderA, dayOfWeek[(day+weekday-1)%7], day)
+			for _, blockDay := range blockCalendar.BlockDays {
+				lineA += fmt.Sprintf(dayHeaderA, daysOfWeek[dayOfWeek(blockDay.Date)], blockDay.Date.Day)
 				lineB += dayHeaderB
 			}
 			lineA += "\n"
 			lineB += "\n"
-			// year, month, day := time.Now().Date()
 
-			header := fmt.Sprintf("%s\nTransit Block Calendar\nFrom: %s - To: %s\n%s\n", agency.Name, Datestamp(start), Datestamp(end), time.Now().Format(layoutCSV))
+			header := fmt.Sprintf("%s\nTransit Block Calendar\nFrom: %s - To: %s\n%s\n", agency.Name, Datestamp(start), Datestamp(end), period)
 			file.WriteString(title + header + lineA + lineB)
 			log.Printf(title + header + lineA + lineB)
 
@@ -211,7 +216,9 @@ func printBlockMonthCSV(feed *gtfsparser.Feed, filename string, blockCalendar *B
 				output += "\n"
 				_, err = file.WriteString(output)
 				log.Printf("%s", output)
-				check(err, "File write error")
+				if err != nil {
+					return err
+				}
 				file.Sync()
 			}
 		}
@@ -219,14 +226,73 @@ func printBlockMonthCSV(feed *gtfsparser.Feed, filename string, blockCalendar *B
 	return err
 }
 
-func printBlockWeekCSV(feed *gtfsparser.Feed, filename string, blockSchedule *BlockSchedule, blockID string, weekEnding gtfs.Date) (err error) {
+// PrintBlockScheduleCSV - prints a block schedule covering an arbitrary date range, one column group per date
+func (s *Scheduler) PrintBlockScheduleCSV(filename string, blockSchedule *BlockSchedule, blockID string) (err error) {
+	feed := s.Feed
 	for _, feedInfo := range feed.FeedInfos {
 		title := feedInfo.Publisher_name + "\n" + "Version:" + feedInfo.Version + "\n"
-		for _, agency := range feed.Agencies {
-			dayOfWeek := findDaysOfWeekAbbrev(feed, agency)
+		for _, agency := range s.agencies() {
+			daysOfWeek := s.daysOfWeekAbbrev(agency)
 			// Create output CSV file
-			file, err := os.Create(agency.Name + "-" + filename)
-			check(err, "File create error")
+			file, err := s.createFile(agency, filename)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			// Get valid date range
+			start, end := getFeedDateRange(feed, 0)
+			// Print header
+			header := fmt.Sprintf("%s\nTransit Block Schedule\nBlock #%s\nFrom: %s - To: %s\n%s\n", agency.Name, blockID, Datestamp(start), Datestamp(end), blockDaysPeriod(blockSchedule.BlockDays))
+			dayHeader := "#,Trip ID,S,D,%s %d,"
+			output := ""
+			tablelength := 0
+			for _, blockDay := range blockSchedule.BlockDays {
+				output += fmt.Sprintf(dayHeader, daysOfWeek[dayOfWeek(blockDay.Date)], blockDay.Date.Day)
+				for _, blocks := range blockDay.Blocks {
+					if tablelength < len(blocks.Trips) {
+						tablelength = len(blocks.Trips)
+					}
+				}
+			}
+			output += "\n"
+			file.WriteString(title + header + output)
+			log.Printf(title + header + output)
+
+			for index := 0; index < tablelength; index++ {
+				var output string
+				for _, blockDay := range blockSchedule.BlockDays {
+					if len(blockDay.Blocks) != 0 {
+						trips := blockDay.Blocks[0].Trips
+						output += BlockItemCSV(feed, trips, len(trips), index)
+					} else {
+						output += ",,,,,"
+					}
+				}
+				output += "\n"
+				_, err = file.WriteString(output)
+				log.Printf("%s", output)
+				if err != nil {
+					return err
+				}
+				file.Sync()
+			}
+		}
+	}
+	return err
+}
+
+// PrintBlockWeekCSV -
+func (s *Scheduler) PrintBlockWeekCSV(filename string, blockSchedule *BlockSchedule, blockID string, weekEnding gtfs.Date) (err error) {
+	feed := s.Feed
+	for _, feedInfo := range feed.FeedInfos {
+		title := feedInfo.Publisher_name + "\n" + "Version:" + feedInfo.Version + "\n"
+		for _, agency := range s.agencies() {
+			dayOfWeek := s.daysOfWeekAbbrev(agency)
+			// Create output CSV file
+			file, err := s.createFile(agency, filename)
+			if err != nil {
+				return err
+			}
 			defer file.Close()
 			// Get valid date range
 			start, end := getFeedDateRange(feed, 0)
@@ -275,7 +341,9 @@ func printBlockWeekCSV(feed *gtfsparser.Feed, filename string, blockSchedule *Bl
 				output += "\n"
 				_, err = file.WriteString(output)
 				log.Printf("%s", line)
-				check(err, "File write error")
+				if err != nil {
+					return err
+				}
 				file.Sync()
 			}
 		}
@@ -300,13 +368,12 @@ func (s ByDepartureTime) Less(i, j int) bool {
 	return toSeconds(s.Trips[i].StopTimes[0].Departure_time) < toSeconds(s.Trips[j].StopTimes[0].Departure_time)
 }
 
-func sortBlockSchedule(blockSchedule BlockSchedule) {
-	for weekday := 0; weekday < 7; weekday++ {
-		if len(blockSchedule.BlockDays) != 0 {
-			if blockSchedule.BlockDays[weekday] != nil {
-				for _, blocks := range blockSchedule.BlockDays[weekday].Blocks {
-					sort.Sort(ByDepartureTime{blocks.Trips})
-				}
+// SortBlockSchedule -
+func SortBlockSchedule(blockSchedule BlockSchedule) {
+	for _, day := range blockSchedule.BlockDays {
+		if day != nil {
+			for _, blocks := range day.Blocks {
+				sort.Sort(ByDepartureTime{blocks.Trips})
 			}
 		}
 	}
@@ -329,7 +396,8 @@ func (s ByStartTime) Less(i, j int) bool {
 	return toSeconds(s.Blocks[i].StartAt) < toSeconds(s.Blocks[j].StartAt)
 }
 
-func sortBlockCalendar(blockCalendar BlockCalendar) {
+// SortBlockCalendar -
+func SortBlockCalendar(blockCalendar BlockCalendar) {
 
 	for _, day := range blockCalendar.BlockDays {
 		if day != nil {
@@ -349,106 +417,83 @@ func sortBlockCalendar(blockCalendar BlockCalendar) {
 	}
 }
 
-func createWeekSchedule(blocktable *Blocktable, weekEnding gtfs.Date) (blockSchedule BlockSchedule) {
-	blockSchedule = BlockSchedule{}
-	startOfWeek := toDate(toTime(weekEnding).Add(-time.Hour * 7 * 24).Date())
-	for weekday := 0; weekday < 7; weekday++ {
-		blockDay := BlockDay{}
-		blockDay.Date = DateAdd(startOfWeek, weekday)
-		blockSchedule.BlockDays = append(blockSchedule.BlockDays, &blockDay)
-	}
-	for weekday := 0; weekday < len(ServicesWeek); weekday++ {
-		// log.Printf("\nWeekday[%d]:", weekday)
-		// output := ""
+// createBlock - returns the block of trips operating on the specified date, or nil if the block does not run that day
+func (s *Scheduler) createBlock(blocktable *Blocktable, date gtfs.Date) (block *Block) {
+	for _, service := range s.ServicesOn(date) {
 		for _, servicetable := range blocktable.Servicetables {
-			for _, service := range ServicesWeek[weekday] {
-				if servicetable.Service.Id == service.Id {
-					for _, trip := range servicetable.Trips {
-						weekDuration := time.Duration(7-weekday) * 24 * time.Hour
-						weekdate := toTime(weekEnding).Add(-weekDuration)
-						if weekdate.After(toTime(trip.Service.Start_date)) && weekdate.Before(toTime(trip.Service.End_date)) {
-							if blockSchedule.BlockDays[weekday].Blocks == nil {
-								block := Block{}
-								block.BlockID = blocktable.BlockID
-								blockSchedule.BlockDays[weekday].Blocks = append(blockSchedule.BlockDays[weekday].Blocks, &block)
-							}
-							for _, block := range blockSchedule.BlockDays[weekday].Blocks {
-								block.Trips = append(block.Trips, trip)
-								// output += fmt.Sprintf("[%s]%s-%s-%s", block.BlockID, trip.Route.Short_name, trip.Service.Id, Timestamp(trip.StopTimes[0].Departure_time, hhmm))
-							}
-						}
-					}
+			if servicetable.Service.Id == service.Id {
+				if block == nil {
+					block = &Block{}
+					block.BlockID = blocktable.BlockID
 				}
+				block.Trips = append(block.Trips, servicetable.Trips...)
 			}
 		}
-		// log.Println(output)
 	}
-	return blockSchedule
-}
-
-func dayOfWeek(date gtfs.Date) (day int) {
-	return int(toTime(date).Weekday())
+	return block
 }
 
-func weeksThisMonth() (weeks int) {
-	year, month, _ := time.Now().Date()
-	first := dayOfWeek(toDate(year, month, 1))
-	last := dayOfWeek(toDate(year, month, daysThisMonth()))
-	weeks = (daysThisMonth() + (first + last)) / 7
-	return weeks
-}
-
-// DateAdd -
-func DateAdd(date gtfs.Date, days int) (result gtfs.Date) {
-	return toDate(toTime(date).Add(time.Hour * time.Duration(days*24)).Date())
+// CreateWeekSchedule -
+func (s *Scheduler) CreateWeekSchedule(blocktable *Blocktable, weekEnding gtfs.Date) (blockSchedule BlockSchedule) {
+	blockSchedule = BlockSchedule{}
+	for weekday := 0; weekday < 7; weekday++ {
+		blockDay := BlockDay{}
+		blockDay.Date = weekDate(weekEnding, weekday)
+		if block := s.createBlock(blocktable, blockDay.Date); block != nil {
+			blockDay.Blocks = append(blockDay.Blocks, block)
+		}
+		blockSchedule.BlockDays = append(blockSchedule.BlockDays, &blockDay)
+	}
+	return blockSchedule
 }
 
-func createBlockCalendar(blocktables []*Blocktable) (blockCalendar BlockCalendar) {
+// CreateBlockCalendarRange - creates the calendar of all blocks operating on each date from start to end inclusive
+func (s *Scheduler) CreateBlockCalendarRange(start, end gtfs.Date) (blockCalendar BlockCalendar) {
 	blockCalendar = BlockCalendar{}
-	year, month, _ := time.Now().Date()
-	for day := 1; day <= daysThisMonth(); day++ {
+	for date := start; !DateBefore(end, date); date = DateAdd(date, 1) {
 		blockDay := BlockDay{}
-		blockDay.Date = toDate(year, month, day)
-		blockCalendar.BlockDays = append(blockCalendar.BlockDays, &blockDay)
-	}
-	weekStartDate := toDate(year, month, 1)
-	firstOfMonth := dayOfWeek(weekStartDate)
-	firstOfWeek := firstOfMonth
-	weekEndDate := toDate(year, month, 8-firstOfMonth)
-	for week := 0; week < weeksThisMonth(); week++ {
-		weekdays := 7
-		if week == 0 {
-			weekdays = 8 - firstOfMonth
-			if firstOfMonth == int(time.Sunday) {
-				weekdays = 1
+		blockDay.Date = date
+		for _, blocktable := range s.Blocktables {
+			if block := s.createBlock(blocktable, date); block != nil {
+				blockDay.Blocks = append(blockDay.Blocks, block)
 			}
-		} else if week == weeksThisMonth()-1 {
-			weekdays = 7 - int(weekEndDate.Day)
 		}
-		for _, blocktable := range blocktables {
-			weekSchedule := createWeekSchedule(blocktable, weekEndDate)
-			for day := 0; day < weekdays; day++ {
-				for _, block := range weekSchedule.BlockDays[day+firstOfWeek-1].Blocks {
-					weekStartDay := int(weekStartDate.Day) - 1
-					if week == 3 {
-						log.Println(day, weekStartDay, block.BlockID)
-					}
-					blockCalendar.BlockDays[day+weekStartDay].Blocks = append(blockCalendar.BlockDays[day+weekStartDay].Blocks, block)
-				}
-			}
-		}
-		weekEndDate = DateAdd(weekEndDate, 7)
-		weekStartDate = DateAdd(weekStartDate, weekdays)
-		firstOfWeek = 1
+		blockCalendar.BlockDays = append(blockCalendar.BlockDays, &blockDay)
 	}
 	return blockCalendar
 }
 
-func createBlockSchedule(blocktables []*Blocktable, blockID string, weekEnding gtfs.Date) (blockSchedule BlockSchedule) {
+// CreateBlockCalendar - creates the calendar of all blocks for the current month in the Scheduler time zone
+func (s *Scheduler) CreateBlockCalendar() (blockCalendar BlockCalendar) {
+	monthStarting := ThisMonth(s.Location())
+	return s.CreateBlockCalendarRange(monthStarting, DateAdd(monthStarting, daysInMonth(monthStarting)-1))
+}
+
+// CreateBlockSchedule -
+func (s *Scheduler) CreateBlockSchedule(blockID string, weekEnding gtfs.Date) (blockSchedule BlockSchedule) {
 	blockSchedule = BlockSchedule{}
-	for _, blocktable := range blocktables {
+	for _, blocktable := range s.Blocktables {
+		if blocktable.BlockID == blockID {
+			blockSchedule = s.CreateWeekSchedule(blocktable, weekEnding)
+			break
+		}
+	}
+	return blockSchedule
+}
+
+// CreateBlockScheduleRange - creates the schedule of the specified block for each date from start to end inclusive
+func (s *Scheduler) CreateBlockScheduleRange(blockID string, start, end gtfs.Date) (blockSchedule BlockSchedule) {
+	blockSchedule = BlockSchedule{}
+	for _, blocktable := range s.Blocktables {
 		if blocktable.BlockID == blockID {
-			blockSchedule = createWeekSchedule(blocktable, weekEnding)
+			for date := start; !DateBefore(end, date); date = DateAdd(date, 1) {
+				blockDay := BlockDay{}
+				blockDay.Date = date
+				if block := s.createBlock(blocktable, date); block != nil {
+					blockDay.Blocks = append(blockDay.Blocks, block)
+				}
+				blockSchedule.BlockDays = append(blockSchedule.BlockDays, &blockDay)
+			}
 			break
 		}
 	}
@@ -457,8 +502,9 @@ func createBlockSchedule(blocktables []*Blocktable, blockID string, weekEnding g
 
 // DeadheadDay -
 type DeadheadDay struct {
-	Date  gtfs.Date
-	Trips []*gtfs.Trip
+	Date      gtfs.Date
+	Trips     []*gtfs.Trip // trips without any pickup or drop-off at some of their stops
+	Deadheads []*Deadhead  // movements between the trips of the blocks and to & from the garage
 }
 
 // DeadheadSchedule -
@@ -520,67 +566,87 @@ func DeadheadItemCSV(feed *gtfsparser.Feed, trips []*gtfs.Trip, max, index int)
 	return text
 }
 
-func createDeadheadSchedule(blocktables []*Blocktable, weekEnding gtfs.Date) (deadheadSchedule DeadheadSchedule) {
+// CreateDeadheadSchedule -
+func (s *Scheduler) CreateDeadheadSchedule(weekEnding gtfs.Date) (deadheadSchedule DeadheadSchedule) {
 	deadheadSchedule = DeadheadSchedule{}
-	startOfWeek := toDate(toTime(weekEnding).Add(-time.Hour * 7 * 24).Date())
 	for weekday := 0; weekday < 7; weekday++ {
 		deadheadDay := DeadheadDay{}
-		deadheadDay.Date = DateAdd(startOfWeek, weekday)
+		deadheadDay.Date = weekDate(weekEnding, weekday)
 		deadheadSchedule.DeadheadDays = append(deadheadSchedule.DeadheadDays, &deadheadDay)
 	}
-	for weekday := 0; weekday < len(ServicesWeek); weekday++ {
+	servicesWeek := s.ServiceWeekEnding(weekEnding)
+	for weekday := 0; weekday < len(servicesWeek); weekday++ {
 		// log.Printf("\nWeekday[%d]:", weekday)
 		// output := ""
-		for _, blocktable := range blocktables {
+		for _, blocktable := range s.Blocktables {
 			for _, servicetable := range blocktable.Servicetables {
-				for _, service := range ServicesWeek[weekday] {
+				for _, service := range servicesWeek[weekday] {
 					if servicetable.Service.Id == service.Id {
 						for _, trip := range servicetable.Trips {
-							weekDuration := time.Duration(7-weekday) * 24 * time.Hour
-							weekdate := toTime(weekEnding).Add(-weekDuration)
-							if weekdate.After(toTime(trip.Service.Start_date)) && weekdate.Before(toTime(trip.Service.End_date)) {
-								trips := deadheadSchedule.DeadheadDays[weekday].Trips
-								var servicedStops, unservicedStops int
-								for _, stopTime := range trip.StopTimes {
-									if StopType(stopTime.Pickup_type) == NoService && StopType(stopTime.Drop_off_type) == NoService {
-										unservicedStops++
-										log.Println(stopTime.Pickup_type, ": No Service [", unservicedStops, "]", stopTime.Stop.Code, stopTime.Sequence, Timestamp(stopTime.Arrival_time, hhmm), trip.Route.Id, trip.Id)
-									} else if StopType(stopTime.Pickup_type) == NoService {
-										log.Println(stopTime.Pickup_type, ": No Pickup: [", unservicedStops, "]", stopTime.Stop.Code, stopTime.Sequence, Timestamp(stopTime.Arrival_time, hhmm), trip.Route.Id, trip.Id)
-									} else if StopType(stopTime.Drop_off_type) == NoService {
-										log.Println(stopTime.Pickup_type, ": No Dropoff: [", unservicedStops, "]", stopTime.Stop.Code, stopTime.Sequence, Timestamp(stopTime.Arrival_time, hhmm), trip.Route.Id, trip.Id)
-									} else {
-										servicedStops++
-									}
-								}
-								if unservicedStops > 0 { // && servicedStops == 0
-									log.Println(": No Service [", weekday, "][", trip.Route.Id, trip.Id, unservicedStops, servicedStops, "]")
-									trips = append(trips, trip)
+							deadheadDay := deadheadSchedule.DeadheadDays[weekday]
+							var servicedStops, unservicedStops int
+							for _, stopTime := range trip.StopTimes {
+								if StopType(stopTime.Pickup_type) == NoService && StopType(stopTime.Drop_off_type) == NoService {
+									unservicedStops++
+									log.Println(stopTime.Pickup_type, ": No Service [", unservicedStops, "]", stopTime.Stop.Code, stopTime.Sequence, Timestamp(stopTime.Arrival_time, hhmm), trip.Route.Id, trip.Id)
+								} else if StopType(stopTime.Pickup_type) == NoService {
+									log.Println(stopTime.Pickup_type, ": No Pickup: [", unservicedStops, "]", stopTime.Stop.Code, stopTime.Sequence, Timestamp(stopTime.Arrival_time, hhmm), trip.Route.Id, trip.Id)
+								} else if StopType(stopTime.Drop_off_type) == NoService {
+									log.Println(stopTime.Pickup_type, ": No Dropoff: [", unservicedStops, "]", stopTime.Stop.Code, stopTime.Sequence, Timestamp(stopTime.Arrival_time, hhmm), trip.Route.Id, trip.Id)
+								} else {
+									servicedStops++
 								}
 							}
+							if unservicedStops > 0 { // && servicedStops == 0
+								log.Println(": No Service [", weekday, "][", trip.Route.Id, trip.Id, unservicedStops, servicedStops, "]")
+								deadheadDay.Trips = append(deadheadDay.Trips, trip)
+							}
 						}
 					}
 				}
 			}
 		}
 	}
+	for _, deadheadDay := range deadheadSchedule.DeadheadDays {
+		for _, blocktable := range s.Blocktables {
+			if blocktable.BlockID == "" {
+				continue
+			}
+			if block := s.createBlock(blocktable, deadheadDay.Date); block != nil {
+				deadheadDay.Deadheads = append(deadheadDay.Deadheads, s.blockDeadheads(block)...)
+			}
+		}
+		sort.Sort(ByDepartureTime{deadheadDay.Trips})
+		deadheads := deadheadDay.Deadheads
+		sort.SliceStable(deadheads, func(i, j int) bool {
+			if deadheads[i].Depart != deadheads[j].Depart {
+				return deadheads[i].Depart < deadheads[j].Depart
+			}
+			return deadheads[i].BlockID < deadheads[j].BlockID
+		})
+		log.Printf("Deadheads %s: %d non-revenue trips, %d deadheads\n", Datestamp(deadheadDay.Date), len(deadheadDay.Trips), len(deadheads))
+	}
 	return deadheadSchedule
 }
 
-func printDeadheadWeekCSV(feed *gtfsparser.Feed, filename string, deadheadSchedule *DeadheadSchedule, weekEnding gtfs.Date) (err error) {
+// PrintDeadheadWeekCSV -
+func (s *Scheduler) PrintDeadheadWeekCSV(filename string, deadheadSchedule *DeadheadSchedule, weekEnding gtfs.Date) (err error) {
+	feed := s.Feed
 	for _, feedInfo := range feed.FeedInfos {
 		title := feedInfo.Publisher_name + "\n" + "Version:" + feedInfo.Version + "\n"
-		for _, agency := range feed.Agencies {
-			dayOfWeek := findDaysOfWeekAbbrev(feed, agency)
+		for _, agency := range s.agencies() {
+			dayOfWeek := s.daysOfWeekAbbrev(agency)
 			// Create output CSV file
-			file, err := os.Create(agency.Name + "-" + filename)
-			check(err, "File create error")
+			file, err := s.createFile(agency, filename)
+			if err != nil {
+				return err
+			}
 			defer file.Close()
 			// Get valid date range
 			start, end := getFeedDateRange(feed, 0)
 			// Print header
 			header := fmt.Sprintf("%s\nTransit Deadhead Schedule\nFrom: %s - To: %s\n%s\n%s\n", agency.Name, Datestamp(start), Datestamp(end), WeekEnding(weekEnding), WeekDatesCSV(weekEnding))
-			dayHeader := "#,Trip ID,S,D,%s,"
+			dayHeader := "Block,#,Trip ID,S,D,%s,"
 			output := ""
 			for day := time.Monday; day <= time.Saturday; day++ {
 				output += fmt.Sprintf(dayHeader, dayOfWeek[day])
@@ -602,33 +668,50 @@ func printDeadheadWeekCSV(feed *gtfsparser.Feed, filename string, deadheadSchedu
 			for index := 0; index < tablelength; index++ {
 				var line [7]string
 				var output string
-				for weekday := time.Monday; weekday <= time.Saturday; weekday++ {
-					if deadheadSchedule.DeadheadDays[weekday].Trips != nil {
-						trips := deadheadSchedule.DeadheadDays[weekday].Trips
-						line[weekday] = BlockItemCSV(feed, trips, len(trips), index)
-					} else {
-						line[weekday] = ",,,,,"
-					}
+				for _, weekday := range weekOrder(len(deadheadSchedule.DeadheadDays)) {
+					trips := deadheadSchedule.DeadheadDays[weekday].Trips
+					line[weekday] = DeadheadItemCSV(feed, trips, len(trips), index)
 					output += line[weekday]
 				}
-				if deadheadSchedule.DeadheadDays[time.Sunday].Trips != nil {
-					trips := deadheadSchedule.DeadheadDays[time.Sunday].Trips
-					line[time.Sunday] = DeadheadItemCSV(feed, trips, len(trips), index)
-					output += line[time.Sunday]
-				} else {
-					line[time.Sunday] = ",,,,,"
-				}
 				output += "\n"
 				_, err = file.WriteString(output)
 				log.Printf("%s", line)
-				check(err, "File write error")
+				if err != nil {
+					return err
+				}
 				file.Sync()
 			}
+			if err = s.printDeadheadsCSV(file, deadheadSchedule, dayOfWeek); err != nil {
+				return err
+			}
 		}
 	}
 	return err
 }
 
+// printDeadheadsCSV - appends the deadheads of the blocks to the deadhead schedule, a row for each in the order of
+// the days of the week and of their departures
+func (s *Scheduler) printDeadheadsCSV(file *os.File, deadheadSchedule *DeadheadSchedule, dayOfWeek [7]string) error {
+	output := "\nDeadheads\nDate,Day,Block,Type,From Trip,To Trip,From,To,Depart,Arrive,Minutes,Distance (km),Estimate,Slack (min)\n"
+	count := 0
+	for _, weekday := range weekOrder(len(deadheadSchedule.DeadheadDays)) {
+		deadheadDay := deadheadSchedule.DeadheadDays[weekday]
+		for _, d := range deadheadDay.Deadheads {
+			slack := ""
+			if d.Kind == Interlining {
+				slack = DeadheadMinutes(d.Slack)
+			}
+			output += fmt.Sprintf("%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%.2f,%s,%s\n", Datestamp(deadheadDay.Date),
+				dayOfWeek[weekday], d.BlockID, d.Kind, d.FromTripID, d.ToTripID, d.From, d.To, GTFSSeconds(d.Depart),
+				GTFSSeconds(d.Arrive), DeadheadMinutes(d.Arrive-d.Depart), d.Distance, d.Estimate, slack)
+			count++
+		}
+	}
+	log.Printf("Deadheads: %d rows\n", count)
+	_, err := file.WriteString(output)
+	return err
+}
+
 // StopPoint -
 type StopPoint struct {
 	StopID           string
@@ -642,56 +725,28 @@ type StopPoint struct {
 	DistanceTraveled float32
 }
 
-func findStopPointsForTrip(feed *gtfsparser.Feed, tripID string, timingPoint bool) (stopPoints []*StopPoint) {
-	for _, trip := range feed.Trips {
-		if trip.Id == tripID {
-			for _, stopTime := range trip.StopTimes {
-				stopPoint := StopPoint{}
-				stopPoint.StopID = stopTime.Stop.Id
-				stopPoint.StopCode = stopTime.Stop.Code
-				stopPoint.StopName = stopTime.Stop.Name
-				stopPoint.StopDescription = stopTime.Stop.Desc
-				stopPoint.StopSequence = stopTime.Sequence
-				stopPoint.IsTimingPoint = stopTime.Timepoint
-				stopPoint.Lat = stopTime.Stop.Lat
-				stopPoint.Lat = stopTime.Stop.Lat
-				stopPoint.DistanceTraveled = stopTime.Shape_dist_traveled
-				if timingPoint == true {
-					if stopPoint.IsTimingPoint == true {
-						stopPoints = append(stopPoints, &stopPoint)
-					}
-				} else {
+// FindStopPointsForTrip - returns the stops of the trip, or only its timing points, in stop_sequence order
+func (s *Scheduler) FindStopPointsForTrip(tripID string, timingPoint bool) (stopPoints []*StopPoint) {
+	if trip := s.FindTrip(tripID, ""); trip != nil {
+		for _, stopTime := range trip.StopTimes {
+			stopPoint := StopPoint{}
+			stopPoint.StopID = stopTime.Stop.Id
+			stopPoint.StopCode = stopTime.Stop.Code
+			stopPoint.StopName = stopTime.Stop.Name
+			stopPoint.StopDescription = stopTime.Stop.Desc
+			stopPoint.StopSequence = stopTime.Sequence
+			stopPoint.IsTimingPoint = stopTime.Timepoint
+			stopPoint.Lat = stopTime.Stop.Lat
+			stopPoint.Lng = stopTime.Stop.Lon
+			stopPoint.DistanceTraveled = stopTime.Shape_dist_traveled
+			if timingPoint == true {
+				if stopPoint.IsTimingPoint == true {
 					stopPoints = append(stopPoints, &stopPoint)
 				}
+			} else {
+				stopPoints = append(stopPoints, &stopPoint)
 			}
 		}
 	}
 	return stopPoints
 }
-
-func test(data []byte, err error) {
-
-}
-
-func GetStopPointsForTripJSON(feed *gtfsparser.Feed, tripID string, timingPoint bool) (stops map[string]StopPoint) {
-	stopPoints := findStopPointsForTrip(feed, tripID, timingPoint)
-	data, err := json.Marshal(stopPoints)
-	test(data, err)
-	return stops
-}
-
-func SetAuthority(authority string) (authorities map[string]authority) {
-
-}
-
-func SetAgency(agency string) {
-
-}
-
-func GetTrip(tripID string) {
-
-}
-
-func GetStop(stopCode string) {
-
-}
@@ -0,0 +1,216 @@
+package timetable
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"strconv"
+	"time"
+)
+
+// DefaultStartOfDay - the time from the start of the service day at which one operating day ends and the next begins,
+// unless the StartOfDay of the Scheduler is changed
+const DefaultStartOfDay = 4 * time.Hour // 4am
+
+const (
+	secondsPerDay = 24 * 3600
+)
+
+const (
+	layoutISO  = "2006-01-02"
+	layoutUS   = "Monday 2-January-2006"
+	layoutGTFS = "20060102"
+)
+
+// ToDate -
+func ToDate(year int, month time.Month, day int) gtfs.Date {
+	return gtfs.Date{Day: int8(day), Month: int8(month), Year: int16(year)}
+}
+
+// Datestamp -
+func Datestamp(a gtfs.Date) (datestamp string) {
+	return fmt.Sprintf("%04d-%02d-%02d", a.Year, a.Month, a.Day)
+}
+
+// ParseDate - parses an ISO (2006-01-02) or GTFS (20060102) formatted date
+func ParseDate(text string) (date gtfs.Date, err error) {
+	t, err := time.Parse(layoutISO, text)
+	if err != nil {
+		t, err = time.Parse(layoutGTFS, text)
+	}
+	if err != nil {
+		return date, fmt.Errorf("invalid date %q, expected YYYY-MM-DD or YYYYMMDD", text)
+	}
+	return ToDate(t.Date()), nil
+}
+
+// ParseTime - parses a HH:MM or HH:MM:SS time of day
+func ParseTime(text string) (t gtfs.Time, err error) {
+	var hour, minute, second int
+	n, _ := fmt.Sscanf(text, "%d:%d:%d", &hour, &minute, &second)
+	if n < 2 || hour < 0 || hour > 23 || minute < 0 || minute > 59 || second < 0 || second > 59 {
+		return t, fmt.Errorf("invalid time %q, expected HH:MM or HH:MM:SS", text)
+	}
+	return gtfs.Time{Hour: int8(hour), Minute: int8(minute), Second: int8(second)}, nil
+}
+
+// GTFSTime - formats a GTFS time as HH:MM:SS from the start of its service day, so 25:10 stays 25:10:00
+func GTFSTime(t gtfs.Time) string {
+	return fmt.Sprintf("%02d:%02d:%02d", t.Hour, t.Minute, t.Second)
+}
+
+// toSeconds - seconds from the start of the service day, which exceed a day for GTFS times past 24:00
+func toSeconds(input gtfs.Time) int {
+	return (3600 * int(input.Hour)) + (60 * int(input.Minute)) + int(input.Second)
+}
+
+// daysOffset - the whole number of days (rounded down) in the seconds from the start of a day
+func daysOffset(seconds int) int {
+	if seconds < 0 {
+		return -((secondsPerDay - 1 - seconds) / secondsPerDay)
+	}
+	return seconds / secondsPerDay
+}
+
+// ServiceDayOffset - the number of days from its service day to the operating day of a GTFS time. Operating days
+// run from StartOfDay to StartOfDay, so 25:10 stays on its service day while 02:00 belongs to the night of the
+// previous day
+func (s *Scheduler) ServiceDayOffset(t gtfs.Time) int {
+	return daysOffset(toSeconds(t) - toSeconds(s.StartOfDay))
+}
+
+// CalendarDayOffset - the number of days from its service day to the calendar day of a GTFS time, e.g. 1 for 25:10
+func CalendarDayOffset(t gtfs.Time) int {
+	return daysOffset(toSeconds(t))
+}
+
+// Convert GTFS Date to Time @ 12:00:00 noon UTC, so that calendar arithmetic is unaffected by daylight saving
+func toTime(date gtfs.Date) time.Time {
+	return time.Date(int(date.Year), time.Month(date.Month), int(date.Day), 12, 0, 0, 0, time.UTC)
+}
+
+// ServiceDayStart - the instant from which the GTFS times of the service day are measured, which is noon minus 12h
+// in the specified time zone. On daylight saving changeover days this is not midnight
+func ServiceDayStart(date gtfs.Date, loc *time.Location) time.Time {
+	return time.Date(int(date.Year), time.Month(date.Month), int(date.Day), 12, 0, 0, 0, loc).Add(-12 * time.Hour)
+}
+
+// ServiceTime - the instant of a GTFS time, which may be past 24:00, on the service day in the specified time zone
+func ServiceTime(date gtfs.Date, t gtfs.Time, loc *time.Location) time.Time {
+	return ServiceDayStart(date, loc).Add(time.Duration(toSeconds(t)) * time.Second)
+}
+
+// Convert GTFS Date to Time @ StartOfDay in the specified time zone
+func (s *Scheduler) toStartTime(date gtfs.Date, loc *time.Location) time.Time {
+	return ServiceTime(date, s.StartOfDay, loc)
+}
+
+func toDayOfYear(date gtfs.Date) int {
+	return toTime(date).YearDay()
+}
+
+func dayOfWeek(date gtfs.Date) (day int) {
+	return int(toTime(date).Weekday())
+}
+
+// DateAdd - adds a number of calendar days to the date
+func DateAdd(date gtfs.Date, days int) (result gtfs.Date) {
+	return ToDate(toTime(date).AddDate(0, 0, days).Date())
+}
+
+// DaysBetween - the number of calendar days from date a to date b, negative if b is before a
+func DaysBetween(a, b gtfs.Date) int {
+	return int(toTime(b).Sub(toTime(a)).Hours() / 24)
+}
+
+// DateBefore - returns true if date a falls on an earlier calendar day than date b
+func DateBefore(a, b gtfs.Date) bool {
+	if a.Year != b.Year {
+		return a.Year < b.Year
+	}
+	if a.Month != b.Month {
+		return a.Month < b.Month
+	}
+	return a.Day < b.Day
+}
+
+// weekDate - returns the date of the weekday (time.Sunday = 0) within the 7 days ending on weekEnding
+func weekDate(weekEnding gtfs.Date, weekday int) gtfs.Date {
+	return DateAdd(weekEnding, -((dayOfWeek(weekEnding) - weekday + 7) % 7))
+}
+
+// Today - the current date in the specified time zone
+func Today(loc *time.Location) gtfs.Date {
+	return ToDate(time.Now().In(loc).Date())
+}
+
+// NextMonday - the date of the Monday after today in the specified time zone
+func NextMonday(loc *time.Location) (date gtfs.Date) {
+	today := Today(loc)
+	return DateAdd(today, 7-(dayOfWeek(today)+6)%7)
+}
+
+// ThisSunday - today's date in the specified time zone if it is a Sunday, otherwise the date of the coming Sunday
+func ThisSunday(loc *time.Location) gtfs.Date {
+	today := Today(loc)
+	return DateAdd(today, (7-dayOfWeek(today))%7)
+}
+
+// IsWeekEnding - the date is a Sunday, the last day of a timetable week
+func IsWeekEnding(date gtfs.Date) bool {
+	return dayOfWeek(date) == int(time.Sunday)
+}
+
+// ThisMonth - the first day of the current month in the specified time zone
+func ThisMonth(loc *time.Location) gtfs.Date {
+	today := Today(loc)
+	return ToDate(int(today.Year), time.Month(today.Month), 1)
+}
+
+// ThisWorkingWeek - returns true if the specified date & time in in range of the current Timetable Working Week from StartOfDay from (Monday to Monday)
+func (s *Scheduler) ThisWorkingWeek(date gtfs.Date, loc *time.Location) (inRange bool) {
+	specifiedTime := ServiceTime(date, gtfs.Time{Hour: 12, Minute: 0, Second: 0}, loc)
+	nextMonday := NextMonday(loc)
+	endOfWeek := s.toStartTime(nextMonday, loc)
+	startOfWeek := s.toStartTime(DateAdd(nextMonday, -7), loc)
+	inRange = (specifiedTime.After(startOfWeek) && specifiedTime.Before(endOfWeek))
+	return inRange
+}
+
+// WeekEnding -
+func WeekEnding(weekEnding gtfs.Date) (text string) {
+	isoFormat := Datestamp(weekEnding)
+	t, _ := time.Parse(layoutISO, isoFormat)
+	text = "Week Ending: " + t.Format(layoutUS)
+	return text
+}
+
+// daysInMonth - the number of days in the month of the date
+func daysInMonth(date gtfs.Date) (days int) {
+	return time.Date(int(date.Year), time.Month(date.Month)+1, 0, 12, 0, 0, 0, time.UTC).Day()
+}
+
+func dayOfThisMonth(dayOfMonth int) (abbrev string) {
+	return abbrev
+}
+
+// Period -
+func Period(start, end gtfs.Date) (text string) {
+	return "Period: " + Datestamp(start) + " - " + Datestamp(end)
+}
+
+// WeekDates - the day of the month of each day of the week ending on the specified date
+func WeekDates(weekEnding gtfs.Date) (days [7]string) {
+	for i := range days {
+		days[i] = strconv.Itoa(int(DateAdd(weekEnding, i-(len(days)-1)).Day))
+	}
+	return days
+}
+
+// WeekDatesCSV -
+func WeekDatesCSV(weekEnding gtfs.Date) (weekDates string) {
+	days := WeekDates(weekEnding)
+	for _, day := range days {
+		weekDates += ",,,," + day + ","
+	}
+	return weekDates
+}
@@ -0,0 +1,99 @@
+package timetable
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestDayOffsets(t *testing.T) {
+	tests := []struct {
+		startOfDay gtfs.Time
+		time       gtfs.Time
+		service    int // ServiceDayOffset from the start of day
+		calendar   int // CalendarDayOffset
+		clock      string
+	}{
+		{gtfs.Time{Hour: 4}, gtfs.Time{Hour: 3, Minute: 59, Second: 59}, -1, 0, "03:59"},
+		{gtfs.Time{Hour: 4}, gtfs.Time{Hour: 4}, 0, 0, "04:00"},
+		{gtfs.Time{Hour: 4}, gtfs.Time{Hour: 23, Minute: 59, Second: 59}, 0, 0, "23:59"},
+		{gtfs.Time{Hour: 4}, gtfs.Time{Hour: 24}, 0, 1, "00:00"},
+		{gtfs.Time{Hour: 4}, gtfs.Time{Hour: 25, Minute: 10}, 0, 1, "01:10"},
+		{gtfs.Time{Hour: 4}, gtfs.Time{Hour: 27, Minute: 59, Second: 59}, 0, 1, "03:59"},
+		{gtfs.Time{Hour: 4}, gtfs.Time{Hour: 28}, 1, 1, "04:00"},
+		{gtfs.Time{Hour: 4}, gtfs.Time{Hour: 48, Minute: 30}, 1, 2, "00:30"},
+		{gtfs.Time{Hour: 2}, gtfs.Time{Hour: 3}, 0, 0, "03:00"},
+		{gtfs.Time{Hour: 2}, gtfs.Time{Hour: 1, Minute: 30}, -1, 0, "01:30"},
+		{gtfs.Time{Hour: 2}, gtfs.Time{Hour: 26, Minute: 30}, 1, 1, "02:30"},
+		{gtfs.Time{}, gtfs.Time{Hour: 24}, 1, 1, "00:00"},
+	}
+	for _, test := range tests {
+		s := &Scheduler{StartOfDay: test.startOfDay}
+		name := fmt.Sprintf("%02d:%02d:%02d from %02d:00", test.time.Hour, test.time.Minute, test.time.Second,
+			test.startOfDay.Hour)
+		if got := s.ServiceDayOffset(test.time); got != test.service {
+			t.Errorf("%s: ServiceDayOffset = %d, want %d", name, got, test.service)
+		}
+		if got := CalendarDayOffset(test.time); got != test.calendar {
+			t.Errorf("%s: CalendarDayOffset = %d, want %d", name, got, test.calendar)
+		}
+		if got := Timestamp(test.time, hhmm); got != test.clock {
+			t.Errorf("%s: Timestamp = %s, want %s", name, got, test.clock)
+		}
+	}
+}
+
+func TestStopTimesOnOperatingDay(t *testing.T) {
+	dir := writeFeed(t, map[string]string{
+		"trips.txt": "route_id,service_id,trip_id,direction_id,block_id\n" +
+			"R,WK,DAY,0,B1\nR,WK,LATE,0,B1\nR,WK,NIGHT,0,B1\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"DAY,08:00:00,08:00:00,S1,1\nDAY,08:10:00,08:10:00,S2,2\n" +
+			"LATE,25:30:00,25:30:00,S1,1\nLATE,25:40:00,25:40:00,S2,2\n" +
+			"NIGHT,02:00:00,02:00:00,S1,1\nNIGHT,02:10:00,02:10:00,S2,2\n",
+	})
+	defer os.RemoveAll(dir)
+	s, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := ToDate(2026, time.June, 10)
+	tests := []struct {
+		startOfDay gtfs.Time
+		nextDay    bool
+		want       []string // trip ID & service date of each stop time at S1 on the date, in order
+	}{
+		// 25:30 stays on its service day & 02:00 belongs to the operating day before its service day
+		{s.StartOfDay, false, []string{"DAY 2026-06-10", "LATE 2026-06-10", "NIGHT 2026-06-11"}},
+		// By calendar day, 25:30 of the previous service day and 02:00 of the date are shown on the date
+		{s.StartOfDay, true, []string{"LATE 2026-06-09", "NIGHT 2026-06-10", "DAY 2026-06-10"}},
+		// From 01:00, 02:00 stays on its service day & 25:30 belongs to the operating day after its service day
+		{gtfs.Time{Hour: 1}, false, []string{"LATE 2026-06-09", "NIGHT 2026-06-10", "DAY 2026-06-10"}},
+	}
+	if s.StartOfDay != (gtfs.Time{Hour: 4}) {
+		t.Errorf("got a start of day of %s, want 04:00:00", GTFSTime(s.StartOfDay))
+	}
+	for _, test := range tests {
+		s.StartOfDay, s.NextDayDisplay = test.startOfDay, test.nextDay
+		name := fmt.Sprintf("from %02d:00, next day %t", test.startOfDay.Hour, test.nextDay)
+		stopTimes := StopTimes(s.stopTimesOn(stopSet([]*gtfs.Stop{s.Feed.Stops["S1"]}), date))
+		sort.Sort(ByArrivalTime{stopTimes})
+		var got []string
+		for _, stopTime := range stopTimes {
+			got = append(got, stopTime.Trip.Id+" "+Datestamp(stopTime.ServiceDate))
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %q, want %q", name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: got %q, want %q", name, got, test.want)
+				break
+			}
+		}
+	}
+}
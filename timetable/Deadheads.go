@@ -0,0 +1,215 @@
+package timetable
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultDeadheadSpeed - the average speed in km/h of a vehicle running out of service
+const DefaultDeadheadSpeed = 30.0
+
+// How the distance & time of a deadhead are estimated
+const (
+	StraightLineEstimate = "straight-line" // straight line km at the deadhead speed
+	ShapeEstimate        = "shape"         // along the shape & schedule of a trip serving both stops, else straight line
+)
+
+// Kinds of deadhead
+const (
+	PullOut     = "pull-out"      // from the garage to the first stop of the block
+	Interlining = "between-trips" // from the last stop of a trip to the first stop of the next trip of the block
+	PullIn      = "pull-in"       // from the last stop of the block to the garage
+)
+
+// Garage - where the vehicles of the blocks pull out from and pull in to
+type Garage struct {
+	Name string
+	Lat  float32
+	Lon  float32
+}
+
+// EstimateMethods - the supported deadhead estimates
+func EstimateMethods() []string {
+	return []string{StraightLineEstimate, ShapeEstimate}
+}
+
+// Deadhead - a non-revenue movement of the vehicle of a block
+type Deadhead struct {
+	BlockID    string
+	Kind       string
+	FromTripID string // the trip the vehicle arrives with, empty for a pull-out
+	ToTripID   string // the trip the vehicle leaves to operate, empty for a pull-in
+	From       string // stop_id, or the garage name
+	To         string
+	Depart     int // seconds from the start of the service day
+	Arrive     int
+	Distance   float64 // km
+	Estimate   string
+	Slack      int // seconds from the arrival to the departure of the next trip, negative if it cannot be made
+}
+
+// deadheadLeg - the estimated distance & running time between two positions
+type deadheadLeg struct {
+	distance float64
+	duration int // seconds
+	estimate string
+}
+
+// deadheadSpeed - the deadhead speed of the Scheduler, DefaultDeadheadSpeed if unset
+func (s *Scheduler) deadheadSpeed() float64 {
+	if s.DeadheadSpeed > 0 {
+		return s.DeadheadSpeed
+	}
+	return DefaultDeadheadSpeed
+}
+
+// straightLine - the straight line distance between the positions, run at the deadhead speed and rounded up to the
+// minute
+func (s *Scheduler) straightLine(lat1, lon1, lat2, lon2 float32) deadheadLeg {
+	distance := StraightLineDistance(lat1, lon1, lat2, lon2)
+	minutes := math.Ceil(distance / s.deadheadSpeed() * 60)
+	return deadheadLeg{distance, int(minutes) * 60, StraightLineEstimate}
+}
+
+// shapeLeg - the shortest distance between the stops along the shape of a trip calling at the first stop and later at
+// the second, with the scheduled running time of that trip. Shapes shorter than the straight line between the stops
+// do not follow them and are ignored
+func (s *Scheduler) shapeLeg(from, to *gtfs.Stop) (leg deadheadLeg, ok bool) {
+	key := from.Id + "\x00" + to.Id
+	s.shapeLegsLock.Lock()
+	defer s.shapeLegsLock.Unlock()
+	if leg, ok = s.shapeLegs[key]; ok {
+		return leg, leg.estimate != ""
+	}
+	straight := StraightLineDistance(from.Lat, from.Lon, to.Lat, to.Lon)
+	leg.distance = math.MaxFloat64
+	for _, trip := range s.Feed.Trips {
+		for i, stopTime := range trip.StopTimes {
+			if stopTime.Stop != from {
+				continue
+			}
+			for j := i + 1; j < len(trip.StopTimes); j++ {
+				if trip.StopTimes[j].Stop != to {
+					continue
+				}
+				path := newTripPathKm(trip)
+				distance := path.stops[j] - path.stops[i]
+				duration := toSeconds(trip.StopTimes[j].Arrival_time) - toSeconds(stopTime.Departure_time)
+				if distance >= straight && distance < leg.distance && duration > 0 {
+					leg = deadheadLeg{distance, duration, ShapeEstimate}
+				}
+				break
+			}
+		}
+	}
+	if s.shapeLegs == nil {
+		s.shapeLegs = make(map[string]deadheadLeg)
+	}
+	if leg.estimate == "" {
+		leg = deadheadLeg{}
+	}
+	s.shapeLegs[key] = leg
+	return leg, leg.estimate != ""
+}
+
+// stopLeg - the deadhead between two stops, along a trip shape when the estimate is ShapeEstimate and a trip serves
+// both stops in that order
+func (s *Scheduler) stopLeg(from, to *gtfs.Stop) deadheadLeg {
+	if s.DeadheadEstimate == ShapeEstimate {
+		if leg, ok := s.shapeLeg(from, to); ok {
+			return leg
+		}
+	}
+	return s.straightLine(from.Lat, from.Lon, to.Lat, to.Lon)
+}
+
+// blockDeadheads - the deadheads of the vehicles operating the trips of a block on a service day, one for the scheduled
+// trips and one for each trip generated from frequencies.txt
+func (s *Scheduler) blockDeadheads(block *Block) (deadheads []*Deadhead) {
+	for _, vehicle := range s.vehicleBlocks(block) {
+		deadheads = append(deadheads, s.vehicleDeadheads(vehicle)...)
+	}
+	return deadheads
+}
+
+// vehicleDeadheads - the deadheads of the vehicle operating the trips of a block on a service day: a pull-out from the
+// garage, a deadhead wherever a trip starts at another stop than the previous trip ended at, and a pull-in back to the
+// garage. Without a garage only the deadheads between trips are generated
+func (s *Scheduler) vehicleDeadheads(block *Block) (deadheads []*Deadhead) {
+	var trips []*gtfs.Trip
+	for _, trip := range block.Trips {
+		if len(trip.StopTimes) > 0 {
+			trips = append(trips, trip)
+		}
+	}
+	if len(trips) == 0 {
+		return nil
+	}
+	sort.Sort(ByDepartureTime{trips})
+	first := trips[0].StopTimes[0]
+	if s.Garage != nil {
+		leg := s.straightLine(s.Garage.Lat, s.Garage.Lon, first.Stop.Lat, first.Stop.Lon)
+		deadheads = append(deadheads, &Deadhead{
+			BlockID: block.BlockID, Kind: PullOut, ToTripID: trips[0].Id, From: s.Garage.Name, To: first.Stop.Id,
+			Depart: startSeconds(trips[0]) - leg.duration, Arrive: startSeconds(trips[0]), Distance: leg.distance,
+			Estimate: leg.estimate,
+		})
+	}
+	for i := 1; i < len(trips); i++ {
+		trip, next := trips[i-1], trips[i]
+		last, first := trip.StopTimes[len(trip.StopTimes)-1], next.StopTimes[0]
+		if sameTerminal(last.Stop, first.Stop) {
+			continue
+		}
+		leg := s.stopLeg(last.Stop, first.Stop)
+		arrive := endSeconds(trip) + leg.duration
+		deadheads = append(deadheads, &Deadhead{
+			BlockID: block.BlockID, Kind: Interlining, FromTripID: trip.Id, ToTripID: next.Id, From: last.Stop.Id,
+			To: first.Stop.Id, Depart: endSeconds(trip), Arrive: arrive, Distance: leg.distance, Estimate: leg.estimate,
+			Slack: startSeconds(next) - arrive,
+		})
+	}
+	if s.Garage != nil {
+		trip := trips[len(trips)-1]
+		last := trip.StopTimes[len(trip.StopTimes)-1]
+		leg := s.straightLine(last.Stop.Lat, last.Stop.Lon, s.Garage.Lat, s.Garage.Lon)
+		deadheads = append(deadheads, &Deadhead{
+			BlockID: block.BlockID, Kind: PullIn, FromTripID: trip.Id, From: last.Stop.Id, To: s.Garage.Name,
+			Depart: endSeconds(trip), Arrive: endSeconds(trip) + leg.duration, Distance: leg.distance,
+			Estimate: leg.estimate,
+		})
+	}
+	return deadheads
+}
+
+// GTFSSeconds - formats seconds from the start of the service day as a GTFS time, which may be past 24:00 or, for a
+// pull-out before midnight, negative
+func GTFSSeconds(seconds int) string {
+	sign := ""
+	if seconds < 0 {
+		sign, seconds = "-", -seconds
+	}
+	return fmt.Sprintf("%s%02d:%02d:%02d", sign, seconds/3600, seconds/60%60, seconds%60)
+}
+
+// DeadheadMinutes - formats seconds as whole minutes
+func DeadheadMinutes(seconds int) string {
+	return fmt.Sprintf("%d", int(math.Round(float64(seconds)/60)))
+}
+
+// ParseGarage - parses the position of a garage given as "lat,lon"
+func ParseGarage(name, position string) (*Garage, error) {
+	parts := strings.Split(position, ",")
+	if len(parts) == 2 {
+		lat, errLat := strconv.ParseFloat(strings.TrimSpace(parts[0]), 32)
+		lon, errLon := strconv.ParseFloat(strings.TrimSpace(parts[1]), 32)
+		if errLat == nil && errLon == nil && math.Abs(lat) <= 90 && math.Abs(lon) <= 180 {
+			return &Garage{Name: name, Lat: float32(lat), Lon: float32(lon)}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid position %q, expected lat,lon", position)
+}
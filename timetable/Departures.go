@@ -0,0 +1,80 @@
+package timetable
+
+import (
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"log"
+	"sort"
+)
+
+// Departure - a trip calling at a stop, as shown on signage and kiosks
+type Departure struct {
+	RouteID        string
+	RouteShortName string
+	Headsign       string
+	TripID         string
+	StopCode       string
+	Platform       string
+	Date           string // calendar date of the departure
+	ServiceDate    string // service day of the trip
+	ArrivalTime    string
+	DepartureTime  string
+	PickupType     int
+	DropOffType    int
+}
+
+// DepartureQuery - selects the departures at the stops after a time on a calendar date
+type DepartureQuery struct {
+	Date    gtfs.Date
+	After   gtfs.Time // clock time on the date, from 00:00:00
+	Limit   int       // maximum number of departures, or all of them if zero
+	RouteID string    // restricts the departures to this route_id, if not empty
+}
+
+// departureSeconds - seconds from the start of the calendar date to the departure of the stop time
+func (st *StopTime) departureSeconds(date gtfs.Date) int {
+	return toSeconds(st.DepartureTime) + DaysBetween(date, st.ServiceDate)*secondsPerDay
+}
+
+// Departures - returns the next departures at the stops after the query time, using the same operating days as
+// CreateTimetable. Times before the start of day on the date belong to the previous operating day, so its stop times
+// are searched as well as those of the date and the following day. Stop times without pickup, such as the arrivals at
+// a terminal, are not departures
+func (s *Scheduler) Departures(stops []*gtfs.Stop, query DepartureQuery) (departures []*Departure) {
+	stopIDs := stopSet(stops)
+	after := toSeconds(query.After)
+	var stopTimes StopTimes
+	for days := -1; days <= 1; days++ {
+		for _, stopTime := range s.stopTimesOn(stopIDs, DateAdd(query.Date, days)) {
+			if stopTime.PickupType == 1 || query.RouteID != "" && stopTime.Route.Id != query.RouteID {
+				continue
+			}
+			if stopTime.departureSeconds(query.Date) >= after {
+				stopTimes = append(stopTimes, stopTime)
+			}
+		}
+	}
+	sort.SliceStable(stopTimes, func(i, j int) bool {
+		return stopTimes[i].departureSeconds(query.Date) < stopTimes[j].departureSeconds(query.Date)
+	})
+	if query.Limit > 0 && len(stopTimes) > query.Limit {
+		stopTimes = stopTimes[:query.Limit]
+	}
+	for _, stopTime := range stopTimes {
+		departures = append(departures, &Departure{
+			RouteID:        stopTime.Route.Id,
+			RouteShortName: stopTime.Route.Short_name,
+			Headsign:       stopTime.Headsign,
+			TripID:         stopTime.Trip.Id,
+			StopCode:       stopTime.Stop.Code,
+			Platform:       Platform(stopTime.Stop),
+			Date:           Datestamp(DateAdd(stopTime.ServiceDate, CalendarDayOffset(stopTime.DepartureTime))),
+			ServiceDate:    Datestamp(stopTime.ServiceDate),
+			ArrivalTime:    Timestamp(stopTime.ArrivalTime, hhmmss),
+			DepartureTime:  Timestamp(stopTime.DepartureTime, hhmmss),
+			PickupType:     int(stopTime.PickupType),
+			DropOffType:    int(stopTime.DropOffType),
+		})
+	}
+	log.Printf("Departures %s after %s: %d\n", Datestamp(query.Date), GTFSTime(query.After), len(departures))
+	return departures
+}
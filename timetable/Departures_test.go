@@ -0,0 +1,58 @@
+package timetable
+
+import (
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDepartures(t *testing.T) {
+	// Trips of route R end at S3, and T4 ends at S2, so they have no pickup there
+	dir := writeFeed(t, map[string]string{
+		"routes.txt": "route_id,agency_id,route_short_name,route_long_name,route_type\n" +
+			"R,A,1,Test,3\nR2,A,2,Other,3\n",
+		"trips.txt": "route_id,service_id,trip_id,direction_id,block_id\n" +
+			"R,WK,T1,0,B1\nR2,WK,T2,0,B2\nR,WK,T3,0,B1\nR,WK,T4,0,B3\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence,pickup_type\n" +
+			"T1,08:00:00,08:00:00,S1,1,\nT1,08:10:00,08:10:00,S2,2,\nT1,08:20:00,08:20:00,S3,3,1\n" +
+			"T2,08:05:00,08:05:00,S1,1,\nT2,08:15:00,08:15:00,S2,2,\nT2,08:25:00,08:25:00,S3,3,1\n" +
+			"T3,09:00:00,09:00:00,S1,1,\nT3,09:10:00,09:10:00,S2,2,\nT3,09:20:00,09:20:00,S3,3,1\n" +
+			"T4,08:30:00,08:30:00,S1,1,\nT4,08:40:00,08:40:00,S2,2,1\n",
+	})
+	defer os.RemoveAll(dir)
+	s, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := ToDate(2026, time.June, 10)
+	tests := []struct {
+		name  string
+		stop  string
+		query DepartureQuery
+		want  []string // trip IDs of the departures, in order, including those of the next day
+	}{
+		{"next", "S2", DepartureQuery{Date: date, After: gtfs.Time{Hour: 8}, Limit: 3}, []string{"T1", "T2", "T3"}},
+		{"after", "S2", DepartureQuery{Date: date, After: gtfs.Time{Hour: 8, Minute: 10, Second: 1}, Limit: 2},
+			[]string{"T2", "T3"}},
+		{"at", "S2", DepartureQuery{Date: date, After: gtfs.Time{Hour: 8, Minute: 15}, Limit: 2}, []string{"T2", "T3"}},
+		{"next day", "S2", DepartureQuery{Date: date, After: gtfs.Time{Hour: 9, Minute: 11}, Limit: 2}, []string{"T1", "T2"}},
+		{"no limit", "S2", DepartureQuery{Date: date, After: gtfs.Time{Hour: 9}}, []string{"T3", "T1", "T2", "T3"}},
+		{"route", "S2", DepartureQuery{Date: date, Limit: 1, RouteID: "R2"}, []string{"T2"}},
+		{"route & time", "S2", DepartureQuery{Date: date, After: gtfs.Time{Hour: 8, Minute: 11}, Limit: 1, RouteID: "R"},
+			[]string{"T3"}},
+		{"terminal", "S3", DepartureQuery{Date: date}, nil},
+		{"first stop", "S1", DepartureQuery{Date: date, After: gtfs.Time{Hour: 8, Minute: 20}, Limit: 2},
+			[]string{"T4", "T3"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, departure := range s.Departures([]*gtfs.Stop{s.Feed.Stops[test.stop]}, test.query) {
+			got = append(got, departure.TripID)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package timetable
+
+import (
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"sort"
+	"time"
+)
+
+// TripStop - a stop time of a TripDetail
+type TripStop struct {
+	StopID           string
+	StopCode         string
+	StopName         string
+	StopSequence     int
+	ArrivalTime      string
+	DepartureTime    string
+	Headsign         string
+	PickupType       int
+	DropOffType      int
+	IsTimingPoint    bool
+	DistanceTraveled float32
+}
+
+// TripDetail - a trip of the feed with its stop times
+type TripDetail struct {
+	TripID      string
+	RouteID     string
+	ServiceID   string
+	Headsign    string
+	ShortName   string
+	DirectionID int
+	BlockID     string
+	ShapeID     string
+	StartTime   string
+	EndTime     string
+	StopTimes   []*TripStop
+}
+
+// StopDetail - a stop of the feed with the routes calling at it
+type StopDetail struct {
+	StopID        string
+	StopCode      string
+	StopName      string
+	Description   string
+	Lat           float32
+	Lng           float32
+	LocationType  int
+	ParentStation string
+	Platform      string
+	Timezone      string
+	RouteIDs      []string
+}
+
+// RouteDetail - a route of the feed with its trips
+type RouteDetail struct {
+	RouteID   string
+	AgencyID  string
+	ShortName string
+	LongName  string
+	RouteType int
+	Color     string
+	TextColor string
+	TripIDs   []string
+}
+
+// BlockTrip - a trip of a BlockDetail
+type BlockTrip struct {
+	TripID      string
+	RouteID     string
+	ServiceID   string
+	DirectionID int
+	StartTime   string
+	EndTime     string
+}
+
+// BlockDetail - the trips of a block for each of its services, ordered by departure time
+type BlockDetail struct {
+	BlockID string
+	Trips   []*BlockTrip
+}
+
+// ServiceDate - a calendar_dates.txt exception of a ServiceDetail
+type ServiceDate struct {
+	Date          string
+	ExceptionType string
+}
+
+// ServiceDetail - a service of the feed with its weekdays, exceptions & trip count
+type ServiceDetail struct {
+	ServiceID  string
+	StartDate  string
+	EndDate    string
+	Days       []string
+	Exceptions []*ServiceDate
+	Trips      int
+}
+
+// FindTripDetail - returns the trip with the specified trip_id and its stop times, or nil if there is none
+func (s *Scheduler) FindTripDetail(tripID string) *TripDetail {
+	trip := s.FindTrip(tripID, "")
+	if trip == nil {
+		return nil
+	}
+	tripDetail := TripDetail{
+		TripID:      trip.Id,
+		RouteID:     trip.Route.Id,
+		ServiceID:   trip.Service.Id,
+		Headsign:    trip.Headsign,
+		ShortName:   trip.Short_name,
+		DirectionID: int(trip.Direction_id),
+		BlockID:     trip.Block_id,
+		StopTimes:   []*TripStop{},
+	}
+	if trip.Shape != nil {
+		tripDetail.ShapeID = trip.Shape.Id
+	}
+	for _, stopTime := range trip.StopTimes {
+		tripDetail.StopTimes = append(tripDetail.StopTimes, &TripStop{
+			StopID:           stopTime.Stop.Id,
+			StopCode:         stopTime.Stop.Code,
+			StopName:         stopTime.Stop.Name,
+			StopSequence:     stopTime.Sequence,
+			ArrivalTime:      GTFSTime(stopTime.Arrival_time),
+			DepartureTime:    GTFSTime(stopTime.Departure_time),
+			Headsign:         stopTime.Headsign,
+			PickupType:       int(stopTime.Pickup_type),
+			DropOffType:      int(stopTime.Drop_off_type),
+			IsTimingPoint:    stopTime.Timepoint,
+			DistanceTraveled: stopTime.Shape_dist_traveled,
+		})
+	}
+	if len(trip.StopTimes) != 0 {
+		tripDetail.StartTime = GTFSTime(trip.StopTimes[0].Departure_time)
+		tripDetail.EndTime = GTFSTime(trip.StopTimes[len(trip.StopTimes)-1].Arrival_time)
+	}
+	return &tripDetail
+}
+
+// FindStopDetail - returns the stop with the specified stop_code and the routes calling at it, or nil if there is none
+func (s *Scheduler) FindStopDetail(stopCode string) *StopDetail {
+	stop := s.FindStop(stopCode)
+	if stop == nil {
+		return nil
+	}
+	stopDetail := StopDetail{
+		StopID:       stop.Id,
+		StopCode:     stop.Code,
+		StopName:     stop.Name,
+		Description:  stop.Desc,
+		Lat:          stop.Lat,
+		Lng:          stop.Lon,
+		LocationType: int(stop.Location_type),
+		Platform:     stop.Platform_code,
+		Timezone:     stop.Timezone.GetTzString(),
+		RouteIDs:     []string{},
+	}
+	if stop.Parent_station != nil {
+		stopDetail.ParentStation = stop.Parent_station.Id
+	}
+	routes := make(map[string]bool)
+	for _, trip := range s.Feed.Trips {
+		for _, stopTime := range trip.StopTimes {
+			if stopTime.Stop.Id == stop.Id && !routes[trip.Route.Id] {
+				routes[trip.Route.Id] = true
+				stopDetail.RouteIDs = append(stopDetail.RouteIDs, trip.Route.Id)
+			}
+		}
+	}
+	sort.Strings(stopDetail.RouteIDs)
+	return &stopDetail
+}
+
+// FindRouteDetail - returns the route with the specified route_id and its trips, or nil if there is none
+func (s *Scheduler) FindRouteDetail(routeID string) *RouteDetail {
+	route := s.Feed.Routes[routeID]
+	if route == nil {
+		return nil
+	}
+	routeDetail := RouteDetail{
+		RouteID:   route.Id,
+		ShortName: route.Short_name,
+		LongName:  route.Long_name,
+		RouteType: int(route.Type),
+		Color:     route.Color,
+		TextColor: route.Text_color,
+		TripIDs:   []string{},
+	}
+	if route.Agency != nil {
+		routeDetail.AgencyID = route.Agency.Id
+	}
+	for _, trip := range s.Feed.Trips {
+		if trip.Route.Id == route.Id {
+			routeDetail.TripIDs = append(routeDetail.TripIDs, trip.Id)
+		}
+	}
+	sort.Strings(routeDetail.TripIDs)
+	return &routeDetail
+}
+
+// FindBlockDetail - returns the trips of the block with the specified block_id, or nil if there is none
+func (s *Scheduler) FindBlockDetail(blockID string) *BlockDetail {
+	blocktable := s.FindBlocktable(blockID)
+	if blocktable == nil {
+		return nil
+	}
+	var trips []*gtfs.Trip
+	for _, servicetable := range blocktable.Servicetables {
+		trips = append(trips, servicetable.Trips...)
+	}
+	sort.SliceStable(trips, func(i, j int) bool {
+		if trips[i].Service.Id != trips[j].Service.Id {
+			return trips[i].Service.Id < trips[j].Service.Id
+		}
+		return startSeconds(trips[i]) < startSeconds(trips[j])
+	})
+	blockDetail := BlockDetail{BlockID: blockID, Trips: []*BlockTrip{}}
+	for _, trip := range trips {
+		blockTrip := BlockTrip{
+			TripID:      trip.Id,
+			RouteID:     trip.Route.Id,
+			ServiceID:   trip.Service.Id,
+			DirectionID: int(trip.Direction_id),
+		}
+		if len(trip.StopTimes) != 0 {
+			blockTrip.StartTime = GTFSTime(trip.StopTimes[0].Departure_time)
+			blockTrip.EndTime = GTFSTime(trip.StopTimes[len(trip.StopTimes)-1].Arrival_time)
+		}
+		blockDetail.Trips = append(blockDetail.Trips, &blockTrip)
+	}
+	return &blockDetail
+}
+
+// startSeconds - the departure of the trip from its first stop, in seconds from the start of its service day
+func startSeconds(trip *gtfs.Trip) int {
+	if len(trip.StopTimes) == 0 {
+		return 0
+	}
+	return toSeconds(trip.StopTimes[0].Departure_time)
+}
+
+// FindServiceDetail - returns the service with the specified service_id, or nil if there is none
+func (s *Scheduler) FindServiceDetail(serviceID string) *ServiceDetail {
+	service := s.Feed.Services[serviceID]
+	if service == nil {
+		return nil
+	}
+	serviceDetail := ServiceDetail{
+		ServiceID:  service.Id,
+		StartDate:  Datestamp(service.Start_date),
+		EndDate:    Datestamp(service.End_date),
+		Days:       []string{},
+		Exceptions: []*ServiceDate{},
+	}
+	for _, weekday := range weekOrder(len(service.Daymap)) {
+		if service.Daymap[weekday] {
+			serviceDetail.Days = append(serviceDetail.Days, time.Weekday(weekday).String())
+		}
+	}
+	for date, exception := range service.Exceptions {
+		serviceDetail.Exceptions = append(serviceDetail.Exceptions, &ServiceDate{Datestamp(date), ExceptionType(Exception(exception))})
+	}
+	sort.Slice(serviceDetail.Exceptions, func(i, j int) bool {
+		return serviceDetail.Exceptions[i].Date < serviceDetail.Exceptions[j].Date
+	})
+	if servicetable := s.servicetable(serviceID); servicetable != nil {
+		serviceDetail.Trips = len(servicetable.Trips)
+	}
+	return &serviceDetail
+}
@@ -0,0 +1,269 @@
+package timetable
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DefaultFleetInterval - the width of the time of day bands of the fleet histogram
+const DefaultFleetInterval = 15 * time.Minute
+
+// FleetCount - the most vehicles in service at once and the first minute they are, for a day, a route or a garage
+type FleetCount struct {
+	Name    string
+	Peak    int
+	PeakAt  string // GTFS time from the start of the service day
+	Minutes []int  `json:"-"` // vehicles at each minute from the start of the service day
+}
+
+// FleetDay - the vehicles in service on a service day. A vehicle is in service from the first departure to the last
+// arrival of its block, or of its trip for trips without a block_id, and out of its garage from its pull-out to its
+// pull-in. Vehicles still out past 24:00 of the previous service day are counted in the early hours
+type FleetDay struct {
+	Date      string
+	Peak      int
+	PeakAt    string
+	Routes    []*FleetCount // vehicles running a trip of each route
+	Garages   []*FleetCount // vehicles out of each garage, including the pull-outs & pull-ins
+	Histogram []int         // the most vehicles in service in each band of the interval from the start of the day
+	Minutes   []int         `json:"-"` // vehicles in service at each minute from the start of the day
+}
+
+// Fleet - the vehicle requirement of each service day of a week or date range
+type Fleet struct {
+	Interval int // minutes of each band of the histograms
+	Days     []*FleetDay
+}
+
+// fleetSpan - a period in seconds from the start of the service day, from start inclusive to end exclusive
+type fleetSpan struct {
+	start, end int
+}
+
+// fleetVehicle - the periods a vehicle is in service, out of its garage and running a trip of each route
+type fleetVehicle struct {
+	service fleetSpan
+	garage  *fleetSpan
+	routes  map[string][]fleetSpan
+}
+
+// fleetVehicles - the vehicles of the blocks & unblocked trips operating on the date, offset by the seconds. Each trip
+// generated from frequencies.txt has a vehicle of its own
+func (s *Scheduler) fleetVehicles(date gtfs.Date, offset int) (vehicles []*fleetVehicle) {
+	add := func(block *Block) {
+		vehicle := &fleetVehicle{routes: make(map[string][]fleetSpan)}
+		for _, trip := range block.Trips {
+			if len(trip.StopTimes) == 0 {
+				continue
+			}
+			span := fleetSpan{startSeconds(trip) + offset, endSeconds(trip) + offset}
+			if len(vehicle.routes) == 0 || span.start < vehicle.service.start {
+				vehicle.service.start = span.start
+			}
+			if len(vehicle.routes) == 0 || span.end > vehicle.service.end {
+				vehicle.service.end = span.end
+			}
+			vehicle.routes[trip.Route.Id] = append(vehicle.routes[trip.Route.Id], span)
+		}
+		if len(vehicle.routes) == 0 {
+			return
+		}
+		if s.Garage != nil {
+			garage := vehicle.service
+			for _, deadhead := range s.vehicleDeadheads(block) {
+				switch deadhead.Kind {
+				case PullOut:
+					garage.start = deadhead.Depart + offset
+				case PullIn:
+					garage.end = deadhead.Arrive + offset
+				}
+			}
+			vehicle.garage = &garage
+		}
+		vehicles = append(vehicles, vehicle)
+	}
+	for _, blocktable := range s.Blocktables {
+		block := s.createBlock(blocktable, date)
+		if block == nil {
+			continue
+		}
+		if blocktable.BlockID != "" {
+			for _, vehicle := range s.vehicleBlocks(block) {
+				add(vehicle)
+			}
+			continue
+		}
+		for _, trip := range block.Trips {
+			add(&Block{Trips: []*gtfs.Trip{trip}})
+		}
+	}
+	return vehicles
+}
+
+// fleetMinutes - adds one vehicle to each minute of the span, growing the minutes as needed
+func fleetMinutes(minutes []int, span fleetSpan) []int {
+	first := (span.start + 59) / 60
+	if span.start < 0 {
+		first = 0
+	}
+	for minute := first; minute*60 < span.end; minute++ {
+		for len(minutes) <= minute {
+			minutes = append(minutes, 0)
+		}
+		minutes[minute]++
+	}
+	return minutes
+}
+
+// fleetPeak - the most vehicles in the minutes and the time of the first minute with that many
+func fleetPeak(name string, minutes []int) *FleetCount {
+	count := &FleetCount{Name: name, Minutes: minutes}
+	for minute, vehicles := range minutes {
+		if vehicles > count.Peak {
+			count.Peak = vehicles
+			count.PeakAt = GTFSSeconds(minute * 60)
+		}
+	}
+	return count
+}
+
+// CreateFleetDay - the vehicles in service at each minute of the service day, with the peaks of the day, of each
+// route and of the garage, and the most vehicles in service in each band of the interval
+func (s *Scheduler) CreateFleetDay(date gtfs.Date, interval time.Duration) *FleetDay {
+	day := &FleetDay{Date: Datestamp(date), Minutes: make([]int, secondsPerDay/60), Routes: []*FleetCount{}, Garages: []*FleetCount{}}
+	routes := make(map[string][]int)
+	var garage []int
+	vehicles := append(s.fleetVehicles(DateAdd(date, -1), -secondsPerDay), s.fleetVehicles(date, 0)...)
+	for _, vehicle := range vehicles {
+		day.Minutes = fleetMinutes(day.Minutes, vehicle.service)
+		if vehicle.garage != nil {
+			garage = fleetMinutes(garage, *vehicle.garage)
+		}
+		for routeID, spans := range vehicle.routes {
+			var running []int
+			for _, span := range spans {
+				running = fleetMinutes(running, span)
+			}
+			for minute, trips := range running {
+				if trips > 0 {
+					for len(routes[routeID]) <= minute {
+						routes[routeID] = append(routes[routeID], 0)
+					}
+					routes[routeID][minute]++
+				}
+			}
+		}
+	}
+	peak := fleetPeak(day.Date, day.Minutes)
+	day.Peak, day.PeakAt = peak.Peak, peak.PeakAt
+	for routeID, minutes := range routes {
+		minutes = append(minutes, make([]int, len(day.Minutes)-len(minutes))...)
+		if count := fleetPeak(routeID, minutes); count.Peak > 0 {
+			day.Routes = append(day.Routes, count)
+		}
+	}
+	sort.Slice(day.Routes, func(i, j int) bool { return day.Routes[i].Name < day.Routes[j].Name })
+	if s.Garage != nil {
+		day.Garages = append(day.Garages, fleetPeak(s.Garage.Name, garage))
+	}
+	day.Histogram = FleetHistogram(day.Minutes, interval)
+	log.Printf("Fleet %s: peak %d vehicles at %s, %d routes\n", day.Date, day.Peak, day.PeakAt, len(day.Routes))
+	return day
+}
+
+// FleetHistogram - the most vehicles of the minutes in each band of the interval
+func FleetHistogram(minutes []int, interval time.Duration) (histogram []int) {
+	band := int(interval / time.Minute)
+	if band < 1 {
+		band = 1
+	}
+	for minute, vehicles := range minutes {
+		if minute%band == 0 {
+			histogram = append(histogram, 0)
+		}
+		if last := len(histogram) - 1; vehicles > histogram[last] {
+			histogram[last] = vehicles
+		}
+	}
+	return histogram
+}
+
+// CreateFleet - the vehicle requirement of each date from start to end inclusive
+func (s *Scheduler) CreateFleet(start, end gtfs.Date, interval time.Duration) *Fleet {
+	fleet := &Fleet{Interval: int(interval / time.Minute), Days: []*FleetDay{}}
+	for date := start; !DateBefore(end, date); date = DateAdd(date, 1) {
+		fleet.Days = append(fleet.Days, s.CreateFleetDay(date, interval))
+	}
+	return fleet
+}
+
+// CreateFleetWeek - the vehicle requirement of each day of the week ending on the date, from Monday
+func (s *Scheduler) CreateFleetWeek(weekEnding gtfs.Date, interval time.Duration) *Fleet {
+	return s.CreateFleet(DateAdd(weekEnding, -6), weekEnding, interval)
+}
+
+// PrintFleetCSV - writes the peak vehicles of each day, its garages & routes, followed by the histogram of the
+// vehicles in service by time of day with a column for each day
+func (s *Scheduler) PrintFleetCSV(filename string, fleet *Fleet) error {
+	file, err := s.createFile(s.reportAgency(), filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"Date", "Scope", "Name", "Peak Vehicles", "Peak At"})
+	bands := 0
+	for _, day := range fleet.Days {
+		writer.Write([]string{day.Date, "day", "", strconv.Itoa(day.Peak), day.PeakAt})
+		for _, garage := range day.Garages {
+			writer.Write([]string{day.Date, "garage", garage.Name, strconv.Itoa(garage.Peak), garage.PeakAt})
+		}
+		for _, route := range day.Routes {
+			writer.Write([]string{day.Date, "route", route.Name, strconv.Itoa(route.Peak), route.PeakAt})
+		}
+		if len(day.Histogram) > bands {
+			bands = len(day.Histogram)
+		}
+	}
+	writer.Write(nil)
+	header := []string{"Time"}
+	for _, day := range fleet.Days {
+		header = append(header, day.Date)
+	}
+	writer.Write(header)
+	for band := 0; band < bands; band++ {
+		row := []string{GTFSSeconds(band * fleet.Interval * 60)}
+		for _, day := range fleet.Days {
+			vehicles := 0
+			if band < len(day.Histogram) {
+				vehicles = day.Histogram[band]
+			}
+			row = append(row, strconv.Itoa(vehicles))
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	log.Printf("%s: %d days written\n", filename, len(fleet.Days))
+	return writer.Error()
+}
+
+// PrintFleetJSON - writes the fleet as JSON
+func (s *Scheduler) PrintFleetJSON(filename string, fleet *Fleet) error {
+	data, err := json.MarshalIndent(fleet, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := s.createFile(s.reportAgency(), filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(data)
+	log.Printf("%s: %d days written\n", filename, len(fleet.Days))
+	return err
+}
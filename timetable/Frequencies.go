@@ -0,0 +1,193 @@
+package timetable
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"log"
+)
+
+// Frequency - an entry of frequencies.txt, whose trips depart from start_time every headway_secs until before
+// end_time, each following the stop times of the template trip
+type Frequency struct {
+	Template *gtfs.Trip
+	Start    gtfs.Time
+	End      gtfs.Time
+	Headway  int  // seconds
+	Exact    bool // exact_times=1, otherwise the departures are approximate and shown as a band of the headway
+}
+
+// secondsToTime - the GTFS time of the seconds from the start of the service day
+func secondsToTime(seconds int) gtfs.Time {
+	return gtfs.Time{Hour: int8(seconds / 3600), Minute: int8(seconds / 60 % 60), Second: int8(seconds % 60)}
+}
+
+// frequencyTrip - a copy of the template trip departing at the seconds, named trip_id@HH:MM:SS, with its stop times
+// shifted by the same amount
+func frequencyTrip(template *gtfs.Trip, departure int) *gtfs.Trip {
+	trip := *template
+	trip.Id = template.Id + "@" + GTFSSeconds(departure)
+	trip.Frequencies = nil
+	trip.StopTimes = make(gtfs.StopTimes, len(template.StopTimes))
+	offset := departure - startSeconds(template)
+	for i, stopTime := range template.StopTimes {
+		stopTime.Arrival_time = secondsToTime(toSeconds(stopTime.Arrival_time) + offset)
+		stopTime.Departure_time = secondsToTime(toSeconds(stopTime.Departure_time) + offset)
+		trip.StopTimes[i] = stopTime
+	}
+	return &trip
+}
+
+// expandFrequencies - adds a trip for each departure of each frequency of the template trips to the service tables
+// and the block tables, in place of the templates themselves. The generated trips are kept by the scheduler and not
+// added to the feed, whose trips stay those of trips.txt
+func (s *Scheduler) expandFrequencies(templates []*gtfs.Trip) {
+	if s.frequencies == nil {
+		s.frequencies = make(map[*gtfs.Trip]*Frequency)
+		s.frequencyTrips = make(map[string]*gtfs.Trip)
+	}
+	for _, template := range templates {
+		if len(template.StopTimes) == 0 {
+			continue
+		}
+		for _, entry := range template.Frequencies {
+			frequency := &Frequency{template, entry.Start_time, entry.End_time, entry.Headway_secs, entry.Exact_times}
+			trips := 0
+			for departure := toSeconds(entry.Start_time); departure < toSeconds(entry.End_time); departure += entry.Headway_secs {
+				trip := frequencyTrip(template, departure)
+				if s.frequencyTrips[trip.Id] != nil {
+					continue
+				}
+				s.frequencyTrips[trip.Id] = trip
+				s.frequencies[trip] = frequency
+				s.Servicetables = addTripToServicetable(s.Servicetables, trip)
+				s.Blocktables = addTripToBlocktable(s.Blocktables, trip)
+				trips++
+			}
+			log.Printf("Frequency: trip %s %s-%s every %ds, exact %t, %d trips\n", template.Id, GTFSTime(entry.Start_time),
+				GTFSTime(entry.End_time), entry.Headway_secs, entry.Exact_times, trips)
+		}
+	}
+}
+
+// FrequencyOf - the frequencies.txt entry the trip was generated from, or nil for a scheduled trip
+func (s *Scheduler) FrequencyOf(trip *gtfs.Trip) *Frequency {
+	return s.frequencies[trip]
+}
+
+// FrequencyTrips - the number of trips generated from frequencies.txt
+func (s *Scheduler) FrequencyTrips() int {
+	return len(s.frequencyTrips)
+}
+
+// vehicleBlocks - splits a block into the trips of each of its vehicles. The scheduled trips stay together, while each
+// trip generated from frequencies.txt keeps the block_id of its template but has a vehicle of its own
+func (s *Scheduler) vehicleBlocks(block *Block) (blocks []*Block) {
+	scheduled := &Block{BlockID: block.BlockID, StartAt: block.StartAt, EndAt: block.EndAt}
+	for _, trip := range block.Trips {
+		if s.FrequencyOf(trip) != nil {
+			blocks = append(blocks, &Block{BlockID: block.BlockID, Trips: []*gtfs.Trip{trip}})
+			continue
+		}
+		scheduled.Trips = append(scheduled.Trips, trip)
+	}
+	if len(scheduled.Trips) > 0 || len(blocks) == 0 {
+		blocks = append([]*Block{scheduled}, blocks...)
+	}
+	return blocks
+}
+
+// FindTrip - the trip of trips.txt with the trip_id or, for a trip with frequencies and a start_time (H:MM:SS, as in
+// a GTFS-RT TripDescriptor), the trip generated from it departing at that time. The generated trips are also found by
+// their own ID, trip_id@HH:MM:SS. Returns nil if there is no such trip
+func (s *Scheduler) FindTrip(tripID, startTime string) *gtfs.Trip {
+	trip := s.Feed.Trips[tripID]
+	if trip == nil {
+		return s.frequencyTrips[tripID]
+	}
+	if len(trip.Frequencies) == 0 || startTime == "" {
+		return trip
+	}
+	var hour, minute, second int
+	if n, _ := fmt.Sscanf(startTime, "%d:%d:%d", &hour, &minute, &second); n != 3 {
+		return nil
+	}
+	return s.frequencyTrips[tripID+"@"+GTFSSeconds(hour*3600+minute*60+second)]
+}
+
+// operatingTrips - the trips of trips.txt without frequencies together with the trips generated from frequencies.txt
+func (s *Scheduler) operatingTrips() (trips []*gtfs.Trip) {
+	for _, trip := range s.Feed.Trips {
+		if len(trip.Frequencies) == 0 {
+			trips = append(trips, trip)
+		}
+	}
+	for _, trip := range s.frequencyTrips {
+		trips = append(trips, trip)
+	}
+	return trips
+}
+
+// frequencyBand - identifies the stop times of the trips of a frequency at the same stop of the template
+type frequencyBand struct {
+	frequency *Frequency
+	offset    int // seconds from the first departure of the trip
+	date      gtfs.Date
+}
+
+// frequencyBands - replaces the stop times of the trips of each approximate frequency at each stop by a single stop
+// time, at the first of them, with the headway until the last of them. Exact trips keep their stop times
+func (s *Scheduler) frequencyBands(stopTimes []*StopTime) (banded []*StopTime) {
+	bands := make(map[frequencyBand]*StopTime)
+	for _, stopTime := range stopTimes {
+		frequency := s.frequencies[stopTime.Trip]
+		if frequency == nil || frequency.Exact {
+			banded = append(banded, stopTime)
+			continue
+		}
+		key := frequencyBand{frequency, toSeconds(stopTime.ArrivalTime) - startSeconds(stopTime.Trip), stopTime.ServiceDate}
+		band := bands[key]
+		if band == nil {
+			band = &StopTime{}
+			*band = *stopTime
+			band.Headway, band.Until = frequency.Headway, stopTime.ArrivalTime
+			bands[key] = band
+			banded = append(banded, band)
+			continue
+		}
+		if stopTime.arrivalSeconds() < band.arrivalSeconds() {
+			band.ArrivalTime, band.DepartureTime, band.Trip = stopTime.ArrivalTime, stopTime.DepartureTime, stopTime.Trip
+		}
+		if toSeconds(stopTime.ArrivalTime) > toSeconds(band.Until) {
+			band.Until = stopTime.ArrivalTime
+		}
+	}
+	return banded
+}
+
+// timetableBands - the timetable as printed, with the stop times of approximate frequencies as headway bands. The
+// timetable itself keeps every departure, from which the headways are measured
+func (s *Scheduler) timetableBands(timetable Timetable) (banded Timetable) {
+	for i, stopTimes := range timetable.StopTimes {
+		banded.StopTimes[i] = s.frequencyBands(stopTimes)
+	}
+	return banded
+}
+
+// stopCalendarBands - the stop calendar as printed, with the stop times of approximate frequencies as headway bands
+func (s *Scheduler) stopCalendarBands(stopCalendar StopCalendar) (banded StopCalendar) {
+	for _, stopDay := range stopCalendar.StopDays {
+		banded.StopDays = append(banded.StopDays, &StopDay{stopDay.Date, s.frequencyBands(stopDay.StopTimes)})
+	}
+	return banded
+}
+
+// isBand - the stop time stands for several approximate departures of a frequency
+func (st *StopTime) isBand() bool {
+	return st.Headway > 0 && st.Until != st.ArrivalTime
+}
+
+// FrequencyText - the departures of a headway band, such as "07:00-09:50 every 10 min"
+func FrequencyText(stopTime *StopTime) string {
+	return fmt.Sprintf("%s-%s every %g min", Timestamp(stopTime.ArrivalTime, hhmm), Timestamp(stopTime.Until, hhmm),
+		float64(stopTime.Headway)/60)
+}
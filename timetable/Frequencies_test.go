@@ -0,0 +1,141 @@
+package timetable
+
+import (
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"os"
+	"testing"
+	"time"
+)
+
+// loadFrequencyFeed - a feed whose trip T1 runs every 20 minutes from 06:00 to before 07:00 at approximate times, and
+// T2 every 30 minutes from 08:00 to before 09:00 at exact times
+func loadFrequencyFeed(t *testing.T) (*Scheduler, func()) {
+	dir := writeFeed(t, map[string]string{
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,10:00:00,10:00:00,S1,1\nT1,10:10:00,10:12:00,S2,2\n" +
+			"T2,10:00:00,10:00:00,S1,1\nT2,10:15:00,10:15:00,S3,2\n",
+		"frequencies.txt": "trip_id,start_time,end_time,headway_secs,exact_times\n" +
+			"T1,06:00:00,07:00:00,1200,0\nT2,08:00:00,09:00:00,1800,1\n",
+	})
+	s, err := Load(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func TestExpandFrequencies(t *testing.T) {
+	s, cleanup := loadFrequencyFeed(t)
+	defer cleanup()
+	if len(s.Feed.Trips) != 2 || s.FrequencyTrips() != 5 {
+		t.Fatalf("got %d feed trips & %d generated trips, want 2 & 5", len(s.Feed.Trips), s.FrequencyTrips())
+	}
+	tests := []struct {
+		tripID    string
+		startTime string
+		want      string // ID of the trip found, none if empty
+		departure string // from its second stop
+	}{
+		{"T1", "", "T1", "10:12:00"},
+		{"T1", "06:00:00", "T1@06:00:00", "06:12:00"},
+		{"T1", "6:40:00", "T1@06:40:00", "06:52:00"},
+		{"T1", "06:10:00", "", ""},
+		{"T1", "07:00:00", "", ""},
+		{"T2", "08:30:00", "T2@08:30:00", "08:45:00"},
+		{"T2@08:00:00", "", "T2@08:00:00", "08:15:00"},
+		{"T3", "08:00:00", "", ""},
+	}
+	for _, test := range tests {
+		trip := s.FindTrip(test.tripID, test.startTime)
+		switch {
+		case trip == nil && test.want == "":
+		case trip == nil || trip.Id != test.want:
+			t.Errorf("FindTrip(%q, %q) = %v, want %q", test.tripID, test.startTime, trip, test.want)
+		case GTFSTime(trip.StopTimes[1].Departure_time) != test.departure:
+			t.Errorf("FindTrip(%q, %q) departs from its second stop at %s, want %s", test.tripID, test.startTime,
+				GTFSTime(trip.StopTimes[1].Departure_time), test.departure)
+		case trip.Id != test.tripID && s.FrequencyOf(trip).Template != s.Feed.Trips[test.tripID]:
+			t.Errorf("FrequencyOf(%s) is not that of %s", trip.Id, test.tripID)
+		}
+	}
+}
+
+func TestFrequencyBands(t *testing.T) {
+	s, cleanup := loadFrequencyFeed(t)
+	defer cleanup()
+	weekEnding := ToDate(2026, time.June, 14)
+	stops := []*gtfs.Stop{s.Feed.Stops["S1"], s.Feed.Stops["S2"]}
+	timetable := s.CreateTimetable(stops, weekEnding)
+	SortTimetable(timetable)
+	// The timetable keeps every departure, and only the printed timetable shows the bands
+	if got := len(timetable.StopTimes[0]); got != 8 {
+		t.Errorf("got %d stop times, want 3 of T1 at each of S1 & S2 and 2 of T2 at S1", got)
+	}
+	tests := []struct {
+		stop    string
+		arrival string
+		band    string // FrequencyText of a band, empty for a single departure
+	}{
+		{"S1", "06:00:00", "06:00-06:40 every 20 min"},
+		{"S2", "06:10:00", "06:10-06:50 every 20 min"},
+		{"S1", "08:00:00", ""},
+		{"S1", "08:30:00", ""},
+	}
+	banded := s.timetableBands(timetable).StopTimes[0]
+	if len(banded) != len(tests) {
+		t.Fatalf("got %d printed stop times, want %d", len(banded), len(tests))
+	}
+	for i, test := range tests {
+		stopTime := banded[i]
+		band := ""
+		if stopTime.isBand() {
+			band = FrequencyText(stopTime)
+		}
+		if stopTime.Stop.Id != test.stop || GTFSTime(stopTime.ArrivalTime) != test.arrival || band != test.band {
+			t.Errorf("printed stop time %d is %s at %s %q, want %s at %s %q", i, stopTime.Stop.Id,
+				GTFSTime(stopTime.ArrivalTime), band, test.stop, test.arrival, test.band)
+		}
+	}
+	headways := s.CreateHeadwaysWeek(stops[:1], weekEnding, []TimeBand{{6 * 3600, 7 * 3600}}, 15*time.Minute,
+		TimeBand{6 * 3600, 7 * 3600})
+	if got := headways.Headways[0].Bands[0]; got.Departures != 3 || got.Average != 1200 {
+		t.Errorf("headways of T1 at S1: %d departures every %ds, want 3 every 1200s", got.Departures, got.Average)
+	}
+}
+
+func TestFrequencyVehicles(t *testing.T) {
+	// T1 is the template of 6 trips of 20 minutes every 10 minutes in block B1, whose scheduled trip T2 follows later
+	dir := writeFeed(t, map[string]string{
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,10:00:00,10:00:00,S1,1\nT1,10:20:00,10:20:00,S2,2\n" +
+			"T2,09:00:00,09:00:00,S2,1\nT2,09:20:00,09:20:00,S1,2\n",
+		"frequencies.txt": "trip_id,start_time,end_time,headway_secs,exact_times\n" +
+			"T1,07:00:00,08:00:00,600,0\n",
+	})
+	defer os.RemoveAll(dir)
+	s, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := ToDate(2026, time.June, 10)
+	if fleet := s.CreateFleetDay(date, DefaultFleetInterval); fleet.Peak != 2 || fleet.PeakAt != "07:10:00" {
+		t.Errorf("got a peak of %d vehicles at %s, want 2 at 07:10:00", fleet.Peak, fleet.PeakAt)
+	}
+	if stats := s.CreateOperatingDay(date, make(map[*gtfs.Trip]float64)).Total; stats.Trips != 7 || stats.DeadheadKm != 0 {
+		t.Errorf("got %d trips & %.1f deadhead km, want 7 trips & no deadheads", stats.Trips, stats.DeadheadKm)
+	}
+	s.Garage = &Garage{Name: "G", Lat: 36.05, Lon: -116.05}
+	block := s.createBlock(s.Blocktables[0], date)
+	kinds := make(map[string]int)
+	for _, deadhead := range s.blockDeadheads(block) {
+		kinds[deadhead.Kind]++
+		if deadhead.Slack < 0 {
+			t.Errorf("deadhead from %s to %s has a slack of %ds", deadhead.FromTripID, deadhead.ToTripID, deadhead.Slack)
+		}
+	}
+	if kinds[PullOut] != 7 || kinds[PullIn] != 7 || kinds[Interlining] != 0 {
+		t.Errorf("got %d pull-outs, %d pull-ins & %d deadheads between trips, want 7, 7 & 0", kinds[PullOut],
+			kinds[PullIn], kinds[Interlining])
+	}
+}
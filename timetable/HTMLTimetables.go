@@ -0,0 +1,356 @@
+package timetable
+
+import (
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultTemplateDir - directory of the HTML page templates & their site.css stylesheet
+const DefaultTemplateDir = "templates"
+
+// PageAgency - the agency branding of an HTML page
+type PageAgency struct {
+	Name  string
+	URL   string
+	Phone string
+	Lang  string
+}
+
+// PageColumn - a day (or trip) column of an HTML timetable
+type PageColumn struct {
+	Day     string
+	Date    string
+	Service string
+	Mark    string // footnote marks of the service exceptions on the date
+}
+
+// PageCell - an entry of an HTML timetable column
+type PageCell struct {
+	Empty     bool
+	Route     string
+	Color     string // route_color
+	TextColor string // route_text_color
+	Platform  string
+	Trip      string
+	Service   string
+	Direction string
+	Time      string // HH:MM at the start of each hour, otherwise :MM as in the CSV timetables
+	NewHour   bool
+	Mark      string // footnote mark of a service added by exception
+}
+
+// PageRow - a row of an HTML timetable, labelled for route timetables
+type PageRow struct {
+	Label string
+	Cells []PageCell
+}
+
+// PageNote - a service exception footnote
+type PageNote struct {
+	Mark string
+	Text string
+}
+
+// PageRoute - a route colour legend entry
+type PageRoute struct {
+	Name      string
+	LongName  string
+	Color     string
+	TextColor string
+}
+
+// Page - the data of an HTML timetable page template
+type Page struct {
+	Agency    PageAgency
+	Title     string
+	Subtitle  string
+	Period    string
+	Publisher string
+	Version   string
+	From      string
+	To        string
+	Columns   []PageColumn
+	Rows      []PageRow
+	Notes     []PageNote
+	Routes    []PageRoute
+	Problems  []*Problem // problems of a validation report
+
+	routes map[string]bool
+	notes  map[string]string
+}
+
+// newPage - starts an HTML page with the agency branding & feed version
+func (s *Scheduler) newPage(feedInfo *gtfs.FeedInfo, agency *gtfs.Agency, title string) *Page {
+	page := Page{Title: title, Publisher: feedInfo.Publisher_name, Version: feedInfo.Version}
+	page.Agency = PageAgency{Name: agency.Name, Phone: agency.Phone, Lang: agency.Lang.GetLangString()}
+	if agency.Url != nil {
+		page.Agency.URL = agency.Url.String()
+	}
+	start, end := getFeedDateRange(s.Feed, 0)
+	page.From, page.To = Datestamp(start), Datestamp(end)
+	page.routes = make(map[string]bool)
+	page.notes = make(map[string]string)
+	return &page
+}
+
+// addRoute - adds the route to the colour legend of the page
+func (p *Page) addRoute(route *gtfs.Route, name string) {
+	if p.routes[route.Id] {
+		return
+	}
+	p.routes[route.Id] = true
+	p.Routes = append(p.Routes, PageRoute{Name: name, LongName: route.Long_name, Color: route.Color, TextColor: route.Text_color})
+	sort.Slice(p.Routes, func(i, j int) bool { return p.Routes[i].Name < p.Routes[j].Name })
+}
+
+// routeCell - an entry of the route, in the route colours
+func (p *Page) routeCell(route *gtfs.Route, name, scheduleTime string) PageCell {
+	p.addRoute(route, name)
+	return PageCell{
+		Route:     name,
+		Color:     route.Color,
+		TextColor: route.Text_color,
+		Time:      scheduleTime,
+		NewHour:   !strings.HasPrefix(scheduleTime, ":"),
+	}
+}
+
+// exceptionMarks - adds a footnote for each service exception on the date, returning their marks
+func (s *Scheduler) exceptionMarks(p *Page, agency *gtfs.Agency, date gtfs.Date) (marks string) {
+	d := s.daysOfWeekAbbrev(agency)
+	for _, exception := range s.ServiceExceptions {
+		if exception.Date != date {
+			continue
+		}
+		key := exception.Service.Id + "@" + Datestamp(date)
+		if p.notes[key] == "" {
+			p.notes[key] = string(rune('a' + len(p.Notes)%26))
+			text := "no service " + exception.Service.Id
+			if exception.ExType == Add {
+				text = "extra service " + exception.Service.Id
+			}
+			p.Notes = append(p.Notes, PageNote{Mark: p.notes[key], Text: d[dayOfWeek(date)] + " " + Datestamp(date) + ": " + text})
+		}
+		marks += p.notes[key]
+	}
+	return marks
+}
+
+// exceptionMark - the footnote mark of a service added by exception on the date
+func (p *Page) exceptionMark(service *gtfs.Service, date gtfs.Date) string {
+	if Exception(service.Exceptions[date]) == Add {
+		return p.notes[service.Id+"@"+Datestamp(date)]
+	}
+	return ""
+}
+
+// weekOrder - orders the 7 days of a weekly schedule (time.Sunday = 0) from Monday to Sunday
+func weekOrder(days int) (order []int) {
+	for i := 1; i <= days; i++ {
+		order = append(order, i%days)
+	}
+	return order
+}
+
+// executeTemplate - renders the named template of the Scheduler template directory into a static HTML file of the
+// output directory, alongside a copy of the site.css stylesheet
+func (s *Scheduler) executeTemplate(agency *gtfs.Agency, filename, name string, page *Page) error {
+	dir := s.TemplateDir
+	if dir == "" {
+		dir = DefaultTemplateDir
+	}
+	templates, err := template.ParseGlob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return err
+	}
+	file, err := s.createFile(agency, filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err = templates.ExecuteTemplate(file, name, page); err != nil {
+		return err
+	}
+	log.Printf("%s: %s page written, %d rows\n", filename, name, len(page.Rows))
+	css, err := ioutil.ReadFile(filepath.Join(dir, "site.css"))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(s.OutputDir, "site.css"), css, 0644)
+}
+
+// stopTimesPage - lays out the stop times of each day as the columns of a stop timetable page
+func (s *Scheduler) stopTimesPage(page *Page, agency *gtfs.Agency, dates []gtfs.Date, days [][]*StopTime) {
+	d := s.daysOfWeekAbbrev(agency)
+	tableLength := 0
+	for i, stopTimes := range days {
+		column := PageColumn{Day: d[dayOfWeek(dates[i])], Date: Datestamp(dates[i]), Service: weekdayServiceID(stopTimes)}
+		column.Mark = s.exceptionMarks(page, agency, dates[i])
+		page.Columns = append(page.Columns, column)
+		if len(stopTimes) > tableLength {
+			tableLength = len(stopTimes)
+		}
+	}
+	for index := 0; index < tableLength; index++ {
+		row := PageRow{}
+		for _, stopTimes := range days {
+			if index >= len(stopTimes) {
+				row.Cells = append(row.Cells, PageCell{Empty: true})
+				continue
+			}
+			routeName, scheduleTime := TimeItem(s.Feed, stopTimes, len(stopTimes), index)
+			cell := page.routeCell(stopTimes[index].Route, routeName, scheduleTime)
+			cell.Platform = Platform(stopTimes[index].Stop)
+			cell.Trip = stopTimes[index].Trip.Id
+			cell.Mark = page.exceptionMark(stopTimes[index].Service, stopTimes[index].ServiceDate)
+			row.Cells = append(row.Cells, cell)
+		}
+		page.Rows = append(page.Rows, row)
+	}
+}
+
+// PrintTimetableHTML - writes the weekly stop timetable as a static HTML page
+func (s *Scheduler) PrintTimetableHTML(filename string, timetable Timetable, stops []*gtfs.Stop, weekEnding gtfs.Date) (err error) {
+	timetable = s.timetableBands(timetable)
+	for _, feedInfo := range s.Feed.FeedInfos {
+		for _, agency := range s.agencies() {
+			page := s.newPage(feedInfo, agency, "Transit Schedule")
+			page.Subtitle = StopsTitle(stops)
+			page.Period = WeekEnding(weekEnding)
+			var dates []gtfs.Date
+			var days [][]*StopTime
+			for _, weekday := range weekOrder(len(timetable.StopTimes)) {
+				dates = append(dates, weekDate(weekEnding, weekday))
+				days = append(days, timetable.StopTimes[weekday])
+			}
+			s.stopTimesPage(page, agency, dates, days)
+			if err = s.executeTemplate(agency, filename, "site.html", page); err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+// PrintStopCalendarHTML - writes the stop timetable for a date range as a static HTML page
+func (s *Scheduler) PrintStopCalendarHTML(filename string, stopCalendar StopCalendar, stops []*gtfs.Stop) (err error) {
+	stopCalendar = s.stopCalendarBands(stopCalendar)
+	for _, feedInfo := range s.Feed.FeedInfos {
+		for _, agency := range s.agencies() {
+			page := s.newPage(feedInfo, agency, "Transit Schedule")
+			page.Subtitle = StopsTitle(stops)
+			var dates []gtfs.Date
+			var days [][]*StopTime
+			for _, stopDay := range stopCalendar.StopDays {
+				dates = append(dates, stopDay.Date)
+				days = append(days, stopDay.StopTimes)
+			}
+			if len(dates) != 0 {
+				page.Period = Period(dates[0], dates[len(dates)-1])
+			}
+			s.stopTimesPage(page, agency, dates, days)
+			if err = s.executeTemplate(agency, filename, "site.html", page); err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+// blockPage - lays out the trips of the block on each day as the columns of a block sheet page
+func (s *Scheduler) blockPage(page *Page, agency *gtfs.Agency, blockDays []*BlockDay) {
+	d := s.daysOfWeekAbbrev(agency)
+	tableLength := 0
+	var days [][]*gtfs.Trip
+	for _, blockDay := range blockDays {
+		var trips []*gtfs.Trip
+		if len(blockDay.Blocks) != 0 {
+			trips = blockDay.Blocks[0].Trips
+		}
+		days = append(days, trips)
+		column := PageColumn{Day: d[dayOfWeek(blockDay.Date)], Date: Datestamp(blockDay.Date)}
+		column.Mark = s.exceptionMarks(page, agency, blockDay.Date)
+		page.Columns = append(page.Columns, column)
+		if len(trips) > tableLength {
+			tableLength = len(trips)
+		}
+	}
+	for index := 0; index < tableLength; index++ {
+		row := PageRow{}
+		for i, trips := range days {
+			if index >= len(trips) {
+				row.Cells = append(row.Cells, PageCell{Empty: true})
+				continue
+			}
+			routeName, tripID, serviceID, scheduleTime, directionID := BlockItem(s.Feed, trips, len(trips), index)
+			cell := page.routeCell(trips[index].Route, routeName, scheduleTime)
+			cell.Trip, cell.Service, cell.Direction = tripID, serviceID, directionID
+			cell.Mark = page.exceptionMark(trips[index].Service, blockDays[i].Date)
+			row.Cells = append(row.Cells, cell)
+		}
+		page.Rows = append(page.Rows, row)
+	}
+}
+
+// PrintBlockWeekHTML - writes the weekly block sheet as a static HTML page
+func (s *Scheduler) PrintBlockWeekHTML(filename string, blockSchedule *BlockSchedule, blockID string, weekEnding gtfs.Date) (err error) {
+	var blockDays []*BlockDay
+	for _, weekday := range weekOrder(len(blockSchedule.BlockDays)) {
+		blockDays = append(blockDays, blockSchedule.BlockDays[weekday])
+	}
+	return s.printBlockSheetHTML(filename, blockDays, blockID, WeekEnding(weekEnding))
+}
+
+// PrintBlockScheduleHTML - writes the block sheet for a date range as a static HTML page
+func (s *Scheduler) PrintBlockScheduleHTML(filename string, blockSchedule *BlockSchedule, blockID string) (err error) {
+	return s.printBlockSheetHTML(filename, blockSchedule.BlockDays, blockID, blockDaysPeriod(blockSchedule.BlockDays))
+}
+
+func (s *Scheduler) printBlockSheetHTML(filename string, blockDays []*BlockDay, blockID, period string) (err error) {
+	for _, feedInfo := range s.Feed.FeedInfos {
+		for _, agency := range s.agencies() {
+			page := s.newPage(feedInfo, agency, "Transit Block Schedule")
+			page.Subtitle = "Block #" + blockID
+			page.Period = period
+			s.blockPage(page, agency, blockDays)
+			if err = s.executeTemplate(agency, filename, "block.html", page); err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+// PrintRouteTimetableHTML - writes the route timetable as a static HTML page, timepoints as rows & trips as columns
+func (s *Scheduler) PrintRouteTimetableHTML(filename string, routeTimetable *RouteTimetable) (err error) {
+	for _, feedInfo := range s.Feed.FeedInfos {
+		for _, agency := range s.agencies() {
+			page := s.newPage(feedInfo, agency, "Route "+routeName(routeTimetable.Route))
+			page.Subtitle = routeTimetable.Destination()
+			page.Period = routeDate(routeTimetable.Date)
+			page.addRoute(routeTimetable.Route, routeTimetable.Route.Short_name)
+			mark := s.exceptionMarks(page, agency, routeTimetable.Date)
+			for _, routeTrip := range routeTimetable.Trips {
+				page.Columns = append(page.Columns, PageColumn{Day: routeTrip.TripName(), Service: routeTrip.Trip.Service.Id,
+					Mark: page.exceptionMark(routeTrip.Trip.Service, routeTimetable.Date)})
+			}
+			for row, stop := range routeTimetable.Stops {
+				pageRow := PageRow{Label: stop.Name}
+				for _, routeTrip := range routeTimetable.Trips {
+					pageRow.Cells = append(pageRow.Cells, PageCell{Time: routeTrip.Cell(row), Empty: routeTrip.Times[row] == nil})
+				}
+				page.Rows = append(page.Rows, pageRow)
+			}
+			log.Printf("Route %s exceptions: %s\n", routeTimetable.Route.Id, mark)
+			if err = s.executeTemplate(agency, filename, "route.html", page); err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,358 @@
+package timetable
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of the headway report. The bands cover the operating day from DefaultStartOfDay, and a stop or route
+// is on the frequent network when it is served at least every DefaultFrequentHeadway through DefaultFrequentWindow
+const (
+	DefaultHeadwayBands    = "04:00-06:00,06:00-09:00,09:00-15:00,15:00-18:00,18:00-22:00,22:00-28:00"
+	DefaultFrequentHeadway = 15 * time.Minute
+	DefaultFrequentWindow  = "07:00-19:00"
+)
+
+// TimeBand - a period of the day from Start inclusive to End exclusive, in seconds from the start of the day the stop
+// times are shown under, so a band may run past 24:00
+type TimeBand struct {
+	Start int
+	End   int
+}
+
+// String - formats the band as HH:MM-HH:MM
+func (b TimeBand) String() string {
+	return GTFSSeconds(b.Start)[:5] + "-" + GTFSSeconds(b.End)[:5]
+}
+
+// contains - the seconds are within the band
+func (b TimeBand) contains(seconds int) bool {
+	return seconds >= b.Start && seconds < b.End
+}
+
+// ParseTimeBand - parses a band given as HH:MM-HH:MM, whose end may be past 24:00 but no more than 48:00
+func ParseTimeBand(text string) (band TimeBand, err error) {
+	parts := strings.Split(strings.TrimSpace(text), "-")
+	if len(parts) == 2 {
+		start, errStart := parseBandTime(parts[0])
+		end, errEnd := parseBandTime(parts[1])
+		if errStart == nil && errEnd == nil && start < end {
+			return TimeBand{start, end}, nil
+		}
+	}
+	return band, fmt.Errorf("invalid time band %q, expected HH:MM-HH:MM", text)
+}
+
+// parseBandTime - parses HH:MM as seconds from the start of the day
+func parseBandTime(text string) (int, error) {
+	var hour, minute int
+	if n, _ := fmt.Sscanf(strings.TrimSpace(text), "%d:%d", &hour, &minute); n != 2 || hour < 0 || hour > 48 ||
+		minute < 0 || minute > 59 || hour == 48 && minute > 0 {
+		return 0, fmt.Errorf("invalid time %q", text)
+	}
+	return hour*3600 + minute*60, nil
+}
+
+// ParseTimeBands - parses comma separated bands
+func ParseTimeBands(text string) (bands []TimeBand, err error) {
+	for _, part := range strings.Split(text, ",") {
+		band, err := ParseTimeBand(part)
+		if err != nil {
+			return nil, err
+		}
+		bands = append(bands, band)
+	}
+	return bands, nil
+}
+
+// HeadwayBand - the headways of the departures in a time band. The headway of a departure is the time since the
+// previous departure of the day, which may be in an earlier band
+type HeadwayBand struct {
+	Band       string
+	Departures int
+	Min        int // seconds, zero when no departure of the band has a previous departure
+	Max        int
+	Average    int
+}
+
+// Headways - the departures of a stop on a date, of one route or of all routes when RouteID is empty, with their
+// headways by time band, the largest gap between two departures of the day and the frequent network classification
+type Headways struct {
+	Date         string
+	StopID       string
+	StopCode     string
+	StopName     string
+	RouteID      string
+	Departures   int
+	First        string // GTFS time of the first departure from the start of the day it is shown under
+	Last         string
+	LargestGap   int    // seconds
+	GapFrom      string // GTFS time of the departure before the largest gap
+	GapTo        string
+	Bands        []*HeadwayBand
+	WorstHeadway int // seconds of the longest wait in the frequent window, counted from its start & to its end
+	Frequent     bool
+}
+
+// HeadwayReport - the headways of the stops, and of each route at them, on each date of a week or date range
+type HeadwayReport struct {
+	Bands           []string
+	FrequentHeadway int // seconds
+	FrequentWindow  string
+	Headways        []*Headways
+}
+
+// headwayDepartures - the departures in order of the stop times on a date, in seconds from the start of the day they
+// are shown under. Stop times without pickup are not departures
+func headwayDepartures(date gtfs.Date, stopTimes []*StopTime) (departures []int) {
+	for _, stopTime := range stopTimes {
+		if stopTime.PickupType != 1 {
+			departures = append(departures, stopTime.departureSeconds(date))
+		}
+	}
+	sort.Ints(departures)
+	return departures
+}
+
+// createHeadways - the headways of the departures, in order, of a stop on a date
+func createHeadways(date gtfs.Date, stop *gtfs.Stop, routeID string, departures []int, bands []TimeBand,
+	frequent time.Duration, window TimeBand) *Headways {
+	headways := &Headways{Date: Datestamp(date), StopID: stop.Id, StopCode: stop.Code, StopName: stop.Name,
+		RouteID: routeID, Bands: []*HeadwayBand{}}
+	headways.Departures = len(departures)
+	if len(departures) > 0 {
+		headways.First, headways.Last = GTFSSeconds(departures[0]), GTFSSeconds(departures[len(departures)-1])
+	}
+	for i := 1; i < len(departures); i++ {
+		if gap := departures[i] - departures[i-1]; gap > headways.LargestGap {
+			headways.LargestGap = gap
+			headways.GapFrom, headways.GapTo = GTFSSeconds(departures[i-1]), GTFSSeconds(departures[i])
+		}
+	}
+	for _, band := range bands {
+		headwayBand := &HeadwayBand{Band: band.String()}
+		total, gaps := 0, 0
+		for i, departure := range departures {
+			if !band.contains(departure) {
+				continue
+			}
+			headwayBand.Departures++
+			if i == 0 {
+				continue
+			}
+			gap := departure - departures[i-1]
+			if gaps == 0 || gap < headwayBand.Min {
+				headwayBand.Min = gap
+			}
+			if gap > headwayBand.Max {
+				headwayBand.Max = gap
+			}
+			total += gap
+			gaps++
+		}
+		if gaps > 0 {
+			headwayBand.Average = total / gaps
+		}
+		headways.Bands = append(headways.Bands, headwayBand)
+	}
+	previous := window.Start
+	for _, departure := range departures {
+		if window.contains(departure) {
+			if departure-previous > headways.WorstHeadway {
+				headways.WorstHeadway = departure - previous
+			}
+			previous = departure
+		}
+	}
+	if window.End-previous > headways.WorstHeadway {
+		headways.WorstHeadway = window.End - previous
+	}
+	headways.Frequent = time.Duration(headways.WorstHeadway)*time.Second <= frequent
+	return headways
+}
+
+// CreateHeadwaysDay - the headways at each of the stops on a date from its stop times, for all routes and for each
+// route calling at the stop
+func (s *Scheduler) CreateHeadwaysDay(date gtfs.Date, stops []*gtfs.Stop, stopTimes []*StopTime, bands []TimeBand,
+	frequent time.Duration, window TimeBand) (headways []*Headways) {
+	byStop := make(map[*gtfs.Stop][]*StopTime)
+	for _, stopTime := range stopTimes {
+		byStop[stopTime.Stop] = append(byStop[stopTime.Stop], stopTime)
+	}
+	for _, stop := range stops {
+		if len(byStop[stop]) == 0 {
+			continue
+		}
+		departures := headwayDepartures(date, byStop[stop])
+		headways = append(headways, createHeadways(date, stop, "", departures, bands, frequent, window))
+		byRoute := make(map[string][]*StopTime)
+		var routeIDs []string
+		for _, stopTime := range byStop[stop] {
+			if byRoute[stopTime.Route.Id] == nil {
+				routeIDs = append(routeIDs, stopTime.Route.Id)
+			}
+			byRoute[stopTime.Route.Id] = append(byRoute[stopTime.Route.Id], stopTime)
+		}
+		sort.Strings(routeIDs)
+		for _, routeID := range routeIDs {
+			departures := headwayDepartures(date, byRoute[routeID])
+			headways = append(headways, createHeadways(date, stop, routeID, departures, bands, frequent, window))
+		}
+	}
+	return headways
+}
+
+// StopHeadways - the headways at the stop on a date, of one route or of all routes when routeID is empty, and the
+// departures they are taken from in seconds from the start of the day
+func (s *Scheduler) StopHeadways(stop *gtfs.Stop, date gtfs.Date, routeID string, bands []TimeBand,
+	frequent time.Duration, window TimeBand) (*Headways, []int) {
+	var stopTimes []*StopTime
+	for _, stopTime := range s.stopTimesOn(stopSet([]*gtfs.Stop{stop}), date) {
+		if routeID == "" || stopTime.Route.Id == routeID {
+			stopTimes = append(stopTimes, stopTime)
+		}
+	}
+	departures := headwayDepartures(date, stopTimes)
+	return createHeadways(date, stop, routeID, departures, bands, frequent, window), departures
+}
+
+// BusiestStop - the stop with the most stop times on the date, of the route when routeID is not empty, or nil if
+// there are none
+func (s *Scheduler) BusiestStop(date gtfs.Date, routeID string) (busiest *gtfs.Stop) {
+	counts := make(map[*gtfs.Stop]int)
+	for _, stopTime := range s.stopTimesOn(stopSet(s.AllStops()), date) {
+		if routeID == "" || stopTime.Route.Id == routeID {
+			counts[stopTime.Stop]++
+		}
+	}
+	for stop, count := range counts {
+		if busiest == nil || count > counts[busiest] || count == counts[busiest] && stop.Id < busiest.Id {
+			busiest = stop
+		}
+	}
+	return busiest
+}
+
+// newHeadwayReport - an empty report of the bands and frequent network definition
+func newHeadwayReport(bands []TimeBand, frequent time.Duration, window TimeBand) *HeadwayReport {
+	report := &HeadwayReport{FrequentHeadway: int(frequent / time.Second), FrequentWindow: window.String(),
+		Headways: []*Headways{}}
+	for _, band := range bands {
+		report.Bands = append(report.Bands, band.String())
+	}
+	return report
+}
+
+// sortedStops - the stops in order of stop_code & stop_id
+func sortedStops(stops []*gtfs.Stop) []*gtfs.Stop {
+	sorted := append([]*gtfs.Stop{}, stops...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Code != sorted[j].Code {
+			return sorted[i].Code < sorted[j].Code
+		}
+		return sorted[i].Id < sorted[j].Id
+	})
+	return sorted
+}
+
+// CreateHeadwaysWeek - the headways at the stops for each day of the week of their Timetable, from Monday
+func (s *Scheduler) CreateHeadwaysWeek(stops []*gtfs.Stop, weekEnding gtfs.Date, bands []TimeBand,
+	frequent time.Duration, window TimeBand) *HeadwayReport {
+	report := newHeadwayReport(bands, frequent, window)
+	stops = sortedStops(stops)
+	timetable := s.CreateTimetable(stops, weekEnding)
+	for _, i := range weekOrder(len(timetable.StopTimes)) {
+		date := weekDate(weekEnding, i)
+		report.Headways = append(report.Headways, s.CreateHeadwaysDay(date, stops, timetable.StopTimes[i], bands, frequent, window)...)
+	}
+	s.logHeadways(report)
+	return report
+}
+
+// CreateHeadwaysRange - the headways at the stops for each date from start to end inclusive
+func (s *Scheduler) CreateHeadwaysRange(stops []*gtfs.Stop, start, end gtfs.Date, bands []TimeBand,
+	frequent time.Duration, window TimeBand) *HeadwayReport {
+	report := newHeadwayReport(bands, frequent, window)
+	stops = sortedStops(stops)
+	for _, stopDay := range s.CreateStopCalendar(stops, start, end).StopDays {
+		report.Headways = append(report.Headways, s.CreateHeadwaysDay(stopDay.Date, stops, stopDay.StopTimes, bands, frequent, window)...)
+	}
+	s.logHeadways(report)
+	return report
+}
+
+// logHeadways - logs the size of the frequent network of the report
+func (s *Scheduler) logHeadways(report *HeadwayReport) {
+	frequent := 0
+	for _, headways := range report.Headways {
+		if headways.Frequent && headways.RouteID != "" {
+			frequent++
+		}
+	}
+	log.Printf("Headways: %d stop days & routes, %d frequent routes at stops every %s through %s\n",
+		len(report.Headways), frequent, time.Duration(report.FrequentHeadway)*time.Second, report.FrequentWindow)
+}
+
+// headwayMinutes - formats seconds as minutes to 1 decimal, or empty for no headway
+func headwayMinutes(seconds int) string {
+	if seconds == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(seconds)/60, 'f', 1, 64)
+}
+
+// PrintHeadwaysCSV - writes the headways of each stop & route by time band, followed by their largest gaps and
+// frequent network classification
+func (s *Scheduler) PrintHeadwaysCSV(filename string, report *HeadwayReport) error {
+	file, err := s.createFile(s.reportAgency(), filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"Date", "Stop ID", "Stop Code", "Stop Name", "Route", "Band", "Departures",
+		"Min Headway (min)", "Max Headway (min)", "Average Headway (min)"})
+	for _, headways := range report.Headways {
+		for _, band := range headways.Bands {
+			writer.Write([]string{headways.Date, headways.StopID, headways.StopCode, headways.StopName,
+				headways.RouteID, band.Band, strconv.Itoa(band.Departures), headwayMinutes(band.Min),
+				headwayMinutes(band.Max), headwayMinutes(band.Average)})
+		}
+	}
+	writer.Write(nil)
+	window := fmt.Sprintf("Worst Headway %s (min)", report.FrequentWindow)
+	frequent := fmt.Sprintf("Frequent (every %s min)", headwayMinutes(report.FrequentHeadway))
+	writer.Write([]string{"Date", "Stop ID", "Stop Code", "Stop Name", "Route", "Departures", "First", "Last",
+		"Largest Gap (min)", "Gap From", "Gap To", window, frequent})
+	for _, headways := range report.Headways {
+		writer.Write([]string{headways.Date, headways.StopID, headways.StopCode, headways.StopName, headways.RouteID,
+			strconv.Itoa(headways.Departures), headways.First, headways.Last, headwayMinutes(headways.LargestGap),
+			headways.GapFrom, headways.GapTo, headwayMinutes(headways.WorstHeadway), strconv.FormatBool(headways.Frequent)})
+	}
+	writer.Flush()
+	log.Printf("%s: %d stop days & routes written\n", filename, len(report.Headways))
+	return writer.Error()
+}
+
+// PrintHeadwaysJSON - writes the report as JSON
+func (s *Scheduler) PrintHeadwaysJSON(filename string, report *HeadwayReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := s.createFile(s.reportAgency(), filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(data)
+	log.Printf("%s: %d stop days & routes written\n", filename, len(report.Headways))
+	return err
+}
@@ -0,0 +1,80 @@
+package timetable
+
+import (
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"testing"
+	"time"
+)
+
+// testDepartures - the departures given as HH:MM in seconds from the start of the day
+func testDepartures(t *testing.T, times ...string) (departures []int) {
+	for _, text := range times {
+		seconds, err := parseBandTime(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		departures = append(departures, seconds)
+	}
+	return departures
+}
+
+func TestFrequentHeadways(t *testing.T) {
+	stop := &gtfs.Stop{Id: "S1", Code: "101", Name: "First"}
+	window := TimeBand{7 * 3600, 8 * 3600}
+	tests := []struct {
+		name       string
+		departures []string
+		worst      int // seconds
+		frequent   bool
+	}{
+		{"every 10 min", []string{"07:00", "07:10", "07:20", "07:30", "07:40", "07:50"}, 600, true},
+		{"every 15 min", []string{"07:00", "07:15", "07:30", "07:45"}, 900, true},
+		{"20 min gap", []string{"07:00", "07:10", "07:30", "07:40", "07:50"}, 1200, false},
+		{"late start", []string{"07:20", "07:30", "07:40", "07:50"}, 1200, false},
+		{"early end", []string{"07:00", "07:10", "07:20", "07:30", "07:40"}, 1200, false},
+		{"outside the window", []string{"06:55", "07:10", "07:25", "07:40", "07:55", "08:10"}, 900, true},
+		{"no departures", nil, 3600, false},
+	}
+	for _, test := range tests {
+		headways := createHeadways(ToDate(2026, time.June, 10), stop, "", testDepartures(t, test.departures...), nil,
+			DefaultFrequentHeadway, window)
+		if headways.WorstHeadway != test.worst || headways.Frequent != test.frequent {
+			t.Errorf("%s: worst headway %ds frequent %t, want %ds %t", test.name, headways.WorstHeadway,
+				headways.Frequent, test.worst, test.frequent)
+		}
+	}
+}
+
+func TestHeadwayBands(t *testing.T) {
+	stop := &gtfs.Stop{Id: "S1", Code: "101", Name: "First"}
+	bands, err := ParseTimeBands("06:00-07:00,07:00-08:00,08:00-09:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	departures := testDepartures(t, "06:30", "07:00", "07:10", "07:30", "25:10")
+	headways := createHeadways(ToDate(2026, time.June, 10), stop, "R", departures, bands, DefaultFrequentHeadway,
+		TimeBand{7 * 3600, 8 * 3600})
+	if headways.Departures != 5 || headways.First != "06:30:00" || headways.Last != "25:10:00" {
+		t.Errorf("got %d departures from %s to %s, want 5 from 06:30:00 to 25:10:00", headways.Departures,
+			headways.First, headways.Last)
+	}
+	if headways.LargestGap != 17*3600+40*60 || headways.GapFrom != "07:30:00" || headways.GapTo != "25:10:00" {
+		t.Errorf("got largest gap %ds from %s to %s, want %ds from 07:30:00 to 25:10:00", headways.LargestGap,
+			headways.GapFrom, headways.GapTo, 17*3600+40*60)
+	}
+	tests := []HeadwayBand{
+		// The first departure of the day has no headway
+		{Band: "06:00-07:00", Departures: 1},
+		// The headway of 07:00 is counted from 06:30 in the band before
+		{Band: "07:00-08:00", Departures: 3, Min: 600, Max: 1800, Average: 1200},
+		{Band: "08:00-09:00"},
+	}
+	if len(headways.Bands) != len(tests) {
+		t.Fatalf("got %d bands, want %d", len(headways.Bands), len(tests))
+	}
+	for i, want := range tests {
+		if got := *headways.Bands[i]; got != want {
+			t.Errorf("got band %+v, want %+v", got, want)
+		}
+	}
+}
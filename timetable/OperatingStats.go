@@ -0,0 +1,225 @@
+package timetable
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"log"
+	"sort"
+	"strconv"
+)
+
+// OperatingStats - the trips, revenue hours & km and deadhead hours & km of a route, block, service or date. Revenue
+// time runs from the first departure to the last arrival of each trip. Deadheads are those of the blocks, so routes
+// and services have none
+type OperatingStats struct {
+	Name          string
+	Trips         int
+	RevenueHours  float64
+	RevenueKm     float64
+	DeadheadHours float64
+	DeadheadKm    float64
+}
+
+// OperatingDay - the statistics of a date, in total and by route, block & service
+type OperatingDay struct {
+	Date     string
+	Total    *OperatingStats
+	Routes   []*OperatingStats
+	Blocks   []*OperatingStats
+	Services []*OperatingStats
+}
+
+// OperatingReport - the statistics of each date of a range, and their totals over the range
+type OperatingReport struct {
+	Start    string
+	End      string
+	Total    *OperatingStats
+	Routes   []*OperatingStats
+	Blocks   []*OperatingStats
+	Services []*OperatingStats
+	Days     []*OperatingDay
+}
+
+// add - adds the other statistics
+func (o *OperatingStats) add(other *OperatingStats) {
+	o.Trips += other.Trips
+	o.RevenueHours += other.RevenueHours
+	o.RevenueKm += other.RevenueKm
+	o.DeadheadHours += other.DeadheadHours
+	o.DeadheadKm += other.DeadheadKm
+}
+
+// operatingTotals - the statistics by name, kept in order of their names
+type operatingTotals map[string]*OperatingStats
+
+func (t operatingTotals) get(name string) *OperatingStats {
+	if t[name] == nil {
+		t[name] = &OperatingStats{Name: name}
+	}
+	return t[name]
+}
+
+func (t operatingTotals) sorted() []*OperatingStats {
+	stats := []*OperatingStats{}
+	for _, s := range t {
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// tripKm - the revenue km of the trip along its shape. shape_dist_traveled is used when it matches the shape length
+// in km or in metres, since its units are those of the feed, and otherwise the km between the shape points. Shapes
+// shorter than the straight lines between the stops do not follow them, and the straight lines are used instead
+func tripKm(trip *gtfs.Trip) float64 {
+	if len(trip.StopTimes) < 2 {
+		return 0
+	}
+	straight := 0.0
+	for i := 1; i < len(trip.StopTimes); i++ {
+		a, b := trip.StopTimes[i-1].Stop, trip.StopTimes[i].Stop
+		straight += StraightLineDistance(a.Lat, a.Lon, b.Lat, b.Lon)
+	}
+	path := newTripPathKm(trip)
+	km := path.stops[len(path.stops)-1] - path.stops[0]
+	if km < straight {
+		return straight
+	}
+	first, last := trip.StopTimes[0], trip.StopTimes[len(trip.StopTimes)-1]
+	if !first.Has_dist || !last.Has_dist {
+		return km
+	}
+	shapeDist := float64(last.Shape_dist_traveled - first.Shape_dist_traveled)
+	for _, units := range []float64{1, 1000} {
+		if ratio := shapeDist / units / km; ratio > 0.8 && ratio < 1.25 {
+			return shapeDist / units
+		}
+	}
+	return km
+}
+
+// CreateOperatingDay - the statistics of the trips operating on the date and of the deadheads of their blocks. The
+// km of each trip are cached in tripKms
+func (s *Scheduler) CreateOperatingDay(date gtfs.Date, tripKms map[*gtfs.Trip]float64) *OperatingDay {
+	day := &OperatingDay{Date: Datestamp(date), Total: &OperatingStats{Name: Datestamp(date)}}
+	routes, blocks, services := operatingTotals{}, operatingTotals{}, operatingTotals{}
+	for _, blocktable := range s.Blocktables {
+		block := s.createBlock(blocktable, date)
+		if block == nil {
+			continue
+		}
+		for _, trip := range block.Trips {
+			if len(trip.StopTimes) == 0 {
+				continue
+			}
+			km, ok := tripKms[trip]
+			if !ok {
+				km = tripKm(trip)
+				tripKms[trip] = km
+			}
+			revenue := &OperatingStats{Trips: 1, RevenueHours: float64(endSeconds(trip)-startSeconds(trip)) / 3600, RevenueKm: km}
+			day.Total.add(revenue)
+			routes.get(trip.Route.Id).add(revenue)
+			services.get(trip.Service.Id).add(revenue)
+			if blocktable.BlockID != "" {
+				blocks.get(blocktable.BlockID).add(revenue)
+			}
+		}
+		if blocktable.BlockID == "" {
+			continue
+		}
+		for _, deadhead := range s.blockDeadheads(block) {
+			stats := &OperatingStats{DeadheadHours: float64(deadhead.Arrive-deadhead.Depart) / 3600, DeadheadKm: deadhead.Distance}
+			day.Total.add(stats)
+			blocks.get(blocktable.BlockID).add(stats)
+		}
+	}
+	day.Routes, day.Blocks, day.Services = routes.sorted(), blocks.sorted(), services.sorted()
+	return day
+}
+
+// CreateOperatingReport - the statistics of each date from start to end inclusive and their totals
+func (s *Scheduler) CreateOperatingReport(start, end gtfs.Date) *OperatingReport {
+	report := &OperatingReport{Start: Datestamp(start), End: Datestamp(end), Total: &OperatingStats{Name: "Total"}, Days: []*OperatingDay{}}
+	routes, blocks, services := operatingTotals{}, operatingTotals{}, operatingTotals{}
+	tripKms := make(map[*gtfs.Trip]float64)
+	for date := start; !DateBefore(end, date); date = DateAdd(date, 1) {
+		day := s.CreateOperatingDay(date, tripKms)
+		report.Total.add(day.Total)
+		for _, stats := range day.Routes {
+			routes.get(stats.Name).add(stats)
+		}
+		for _, stats := range day.Blocks {
+			blocks.get(stats.Name).add(stats)
+		}
+		for _, stats := range day.Services {
+			services.get(stats.Name).add(stats)
+		}
+		report.Days = append(report.Days, day)
+	}
+	report.Routes, report.Blocks, report.Services = routes.sorted(), blocks.sorted(), services.sorted()
+	log.Printf("Operating statistics %s - %s: %d trips, %.1f revenue hours, %.1f revenue km, %.1f deadhead hours\n",
+		report.Start, report.End, report.Total.Trips, report.Total.RevenueHours, report.Total.RevenueKm, report.Total.DeadheadHours)
+	return report
+}
+
+// PrintOperatingReportCSV - writes the totals of the range followed by the statistics of each date, each in total
+// and by route, block & service
+func (s *Scheduler) PrintOperatingReportCSV(filename string, report *OperatingReport) error {
+	file, err := s.createFile(s.reportAgency(), filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"Date", "Scope", "Name", "Trips", "Revenue Hours", "Revenue km", "Deadhead Hours", "Deadhead km"})
+	write := func(date, scope string, stats *OperatingStats) {
+		name := stats.Name
+		if scope == "total" {
+			name = ""
+		}
+		writer.Write([]string{date, scope, name, strconv.Itoa(stats.Trips), decimal(stats.RevenueHours),
+			decimal(stats.RevenueKm), decimal(stats.DeadheadHours), decimal(stats.DeadheadKm)})
+	}
+	writeAll := func(date string, total *OperatingStats, routes, blocks, services []*OperatingStats) {
+		write(date, "total", total)
+		for _, stats := range routes {
+			write(date, "route", stats)
+		}
+		for _, stats := range blocks {
+			write(date, "block", stats)
+		}
+		for _, stats := range services {
+			write(date, "service", stats)
+		}
+	}
+	writeAll(report.Start+" - "+report.End, report.Total, report.Routes, report.Blocks, report.Services)
+	for _, day := range report.Days {
+		writeAll(day.Date, day.Total, day.Routes, day.Blocks, day.Services)
+	}
+	writer.Flush()
+	log.Printf("%s: %d days written\n", filename, len(report.Days))
+	return writer.Error()
+}
+
+// decimal - formats hours or km to 2 decimals
+func decimal(value float64) string {
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
+
+// PrintOperatingReportJSON - writes the report as JSON
+func (s *Scheduler) PrintOperatingReportJSON(filename string, report *OperatingReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := s.createFile(s.reportAgency(), filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(data)
+	log.Printf("%s: %d days written\n", filename, len(report.Days))
+	return err
+}
@@ -0,0 +1,391 @@
+package timetable
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/vgpdf"
+	"image/color"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// PaperSize - the page size of the PDF reports, which are laid out in landscape orientation
+type PaperSize struct {
+	Name   string
+	Width  vg.Length
+	Height vg.Length
+}
+
+var (
+	// A4 - 297 x 210 mm landscape
+	A4 = PaperSize{"A4", 842, 595}
+	// Letter - 11 x 8.5 in landscape
+	Letter = PaperSize{"Letter", 792, 612}
+)
+
+// PaperSizes - the supported PDF page sizes
+var PaperSizes = []PaperSize{A4, Letter}
+
+// ParsePaperSize - returns the named page size, ignoring case
+func ParsePaperSize(name string) (paper PaperSize, err error) {
+	for _, paper := range PaperSizes {
+		if strings.EqualFold(paper.Name, name) {
+			return paper, nil
+		}
+	}
+	return paper, fmt.Errorf("invalid paper size %q, expected A4 or Letter", name)
+}
+
+const (
+	pdfMargin      = vg.Length(30)
+	pdfFontSize    = vg.Length(9)
+	pdfMinFontSize = vg.Length(4)
+	pdfPadding     = vg.Length(4)
+)
+
+// pdfTable - the text of a paginated PDF report. The title & header rows are repeated on every page, and the columns
+// are kept together in groups of one day each when the table is too wide for a single page
+type pdfTable struct {
+	title  []string
+	header [][]string
+	rows   [][]string
+	group  int
+}
+
+func (t *pdfTable) columns() (columns int) {
+	for _, row := range append(t.header, t.rows...) {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	return columns
+}
+
+// columnWidths - the width of the widest text of each column
+func (t *pdfTable) columnWidths(regular, bold vg.Font) (widths []vg.Length, total vg.Length) {
+	widths = make([]vg.Length, t.columns())
+	measure := func(rows [][]string, font vg.Font) {
+		for _, row := range rows {
+			for i, text := range row {
+				if w := font.Width(text) + pdfPadding; w > widths[i] {
+					widths[i] = w
+				}
+			}
+		}
+	}
+	measure(t.header, bold)
+	measure(t.rows, regular)
+	for _, w := range widths {
+		total += w
+	}
+	return widths, total
+}
+
+// columnSlices - splits the columns into runs of whole groups that each fit within the available width
+func (t *pdfTable) columnSlices(widths []vg.Length, available vg.Length) (slices [][2]int) {
+	group := t.group
+	if group < 1 {
+		group = len(widths)
+	}
+	first, width := 0, vg.Length(0)
+	for i := 0; i < len(widths); i += group {
+		groupWidth := vg.Length(0)
+		for j := i; j < i+group && j < len(widths); j++ {
+			groupWidth += widths[j]
+		}
+		if width+groupWidth > available && i > first {
+			slices = append(slices, [2]int{first, i})
+			first, width = i, 0
+		}
+		width += groupWidth
+	}
+	return append(slices, [2]int{first, len(widths)})
+}
+
+func pdfFonts(size vg.Length) (regular, bold vg.Font, err error) {
+	if regular, err = vg.MakeFont("Helvetica", size); err != nil {
+		return regular, bold, err
+	}
+	bold, err = vg.MakeFont("Helvetica-Bold", size)
+	return regular, bold, err
+}
+
+// printTablePDF - lays out the table over as many pages as it needs, shrinking the text to fit the page width down to
+// a minimum size and then continuing the remaining column groups on further pages
+func (s *Scheduler) printTablePDF(agency *gtfs.Agency, filename string, table *pdfTable) (err error) {
+	paper := s.Paper
+	if paper.Width == 0 {
+		paper = A4
+	}
+	available := paper.Width - 2*pdfMargin
+	size := pdfFontSize
+	regular, bold, err := pdfFonts(size)
+	if err != nil {
+		return err
+	}
+	widths, total := table.columnWidths(regular, bold)
+	if total > available {
+		size = size * available / total
+		if size < pdfMinFontSize {
+			size = pdfMinFontSize
+		}
+		if regular, bold, err = pdfFonts(size); err != nil {
+			return err
+		}
+		widths, _ = table.columnWidths(regular, bold)
+	}
+	slices := table.columnSlices(widths, available)
+	lineHeight := size * 1.4
+	top := paper.Height - pdfMargin - vg.Length(len(table.title)+len(table.header))*lineHeight
+	rowsPerPage := int((top - pdfMargin - lineHeight) / lineHeight)
+	if rowsPerPage < 1 {
+		rowsPerPage = 1
+	}
+	rowPages := (len(table.rows) + rowsPerPage - 1) / rowsPerPage
+	if rowPages == 0 {
+		rowPages = 1
+	}
+	pages := rowPages * len(slices)
+
+	canvas := vgpdf.New(paper.Width, paper.Height)
+	drawRow := func(y vg.Length, row []string, columns [2]int, font vg.Font) {
+		x := pdfMargin
+		for i := columns[0]; i < columns[1] && i < len(row); i++ {
+			canvas.FillString(font, vg.Point{X: x, Y: y}, row[i])
+			x += widths[i]
+		}
+	}
+	page := 0
+	for _, columns := range slices {
+		for rowPage := 0; rowPage < rowPages; rowPage++ {
+			if page > 0 {
+				canvas.NextPage()
+			}
+			page++
+			canvas.SetColor(color.Black)
+			y := paper.Height - pdfMargin
+			for _, line := range table.title {
+				canvas.FillString(bold, vg.Point{X: pdfMargin, Y: y}, line)
+				y -= lineHeight
+			}
+			for _, row := range table.header {
+				drawRow(y, row, columns, bold)
+				y -= lineHeight
+			}
+			var rule vg.Path
+			rule.Move(vg.Point{X: pdfMargin, Y: y + lineHeight*0.7})
+			rule.Line(vg.Point{X: paper.Width - pdfMargin, Y: y + lineHeight*0.7})
+			canvas.SetLineWidth(0.5)
+			canvas.Stroke(rule)
+			for index := rowPage * rowsPerPage; index < len(table.rows) && index < (rowPage+1)*rowsPerPage; index++ {
+				drawRow(y, table.rows[index], columns, regular)
+				y -= lineHeight
+			}
+			footer := fmt.Sprintf("Page %d of %d", page, pages)
+			canvas.FillString(regular, vg.Point{X: paper.Width - pdfMargin - regular.Width(footer), Y: pdfMargin / 2}, footer)
+		}
+	}
+
+	file, err := s.createFile(agency, filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = canvas.WriteTo(file); err != nil {
+		return err
+	}
+	log.Printf("%s: %d rows on %d %s pages\n", filename, len(table.rows), pages, paper.Name)
+	return nil
+}
+
+// pdfTitle - the lines above each PDF table: agency, report, subject, feed version & period
+func (s *Scheduler) pdfTitle(feedInfo *gtfs.FeedInfo, agency *gtfs.Agency, report, subject, period string) []string {
+	start, end := getFeedDateRange(s.Feed, 0)
+	title := []string{agency.Name, report}
+	if subject != "" {
+		title = append(title, subject)
+	}
+	return append(title, feedInfo.Publisher_name+" Version: "+feedInfo.Version+" From: "+Datestamp(start)+" - To: "+Datestamp(end), period)
+}
+
+// printPDF - prints the table built for each agency of each feed version
+func (s *Scheduler) printPDF(filename string, build func(feedInfo *gtfs.FeedInfo, agency *gtfs.Agency) *pdfTable) (err error) {
+	for _, feedInfo := range s.Feed.FeedInfos {
+		for _, agency := range s.agencies() {
+			if err = s.printTablePDF(agency, filename, build(feedInfo, agency)); err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+// stopTimesTable - the stop times of each day as column groups of route, platform & time
+func (s *Scheduler) stopTimesTable(table *pdfTable, agency *gtfs.Agency, dates []gtfs.Date, days [][]*StopTime) {
+	d := s.daysOfWeekAbbrev(agency)
+	var ribbon, names []string
+	tableLength := 0
+	for i, stopTimes := range days {
+		ribbon = append(ribbon, weekdayServiceID(stopTimes), "", strconv.Itoa(int(dates[i].Day)))
+		names = append(names, "#", "P", d[dayOfWeek(dates[i])])
+		if len(stopTimes) > tableLength {
+			tableLength = len(stopTimes)
+		}
+	}
+	table.header = [][]string{ribbon, names}
+	table.group = 3
+	for index := 0; index < tableLength; index++ {
+		var row []string
+		for _, stopTimes := range days {
+			routeName, scheduleTime := TimeItem(s.Feed, stopTimes, len(stopTimes), index)
+			row = append(row, routeName, PlatformItem(stopTimes, len(stopTimes), index), scheduleTime)
+		}
+		table.rows = append(table.rows, row)
+	}
+}
+
+// PrintTimetablePDF - prints the weekly stop timetable as a paginated PDF
+func (s *Scheduler) PrintTimetablePDF(filename string, timetable Timetable, stops []*gtfs.Stop, weekEnding gtfs.Date) error {
+	timetable = s.timetableBands(timetable)
+	return s.printPDF(filename, func(feedInfo *gtfs.FeedInfo, agency *gtfs.Agency) *pdfTable {
+		table := pdfTable{title: s.pdfTitle(feedInfo, agency, "Transit Schedule", StopsTitle(stops), WeekEnding(weekEnding))}
+		var dates []gtfs.Date
+		var days [][]*StopTime
+		for _, weekday := range weekOrder(len(timetable.StopTimes)) {
+			dates = append(dates, weekDate(weekEnding, weekday))
+			days = append(days, timetable.StopTimes[weekday])
+		}
+		s.stopTimesTable(&table, agency, dates, days)
+		return &table
+	})
+}
+
+// PrintStopCalendarPDF - prints the stop timetable for a date range as a paginated PDF
+func (s *Scheduler) PrintStopCalendarPDF(filename string, stopCalendar StopCalendar, stops []*gtfs.Stop) error {
+	stopCalendar = s.stopCalendarBands(stopCalendar)
+	return s.printPDF(filename, func(feedInfo *gtfs.FeedInfo, agency *gtfs.Agency) *pdfTable {
+		var dates []gtfs.Date
+		var days [][]*StopTime
+		for _, stopDay := range stopCalendar.StopDays {
+			dates = append(dates, stopDay.Date)
+			days = append(days, stopDay.StopTimes)
+		}
+		period := ""
+		if len(dates) != 0 {
+			period = Period(dates[0], dates[len(dates)-1])
+		}
+		table := pdfTable{title: s.pdfTitle(feedInfo, agency, "Transit Schedule", StopsTitle(stops), period)}
+		s.stopTimesTable(&table, agency, dates, days)
+		return &table
+	})
+}
+
+// blockDaysTable - the trips of the block on each day as column groups of route, trip, service, direction & time
+func (s *Scheduler) blockDaysTable(table *pdfTable, agency *gtfs.Agency, blockDays []*BlockDay) {
+	d := s.daysOfWeekAbbrev(agency)
+	var names []string
+	var days [][]*gtfs.Trip
+	tableLength := 0
+	for _, blockDay := range blockDays {
+		var trips []*gtfs.Trip
+		for _, block := range blockDay.Blocks {
+			trips = append(trips, block.Trips...)
+		}
+		days = append(days, trips)
+		names = append(names, "#", "Trip ID", "S", "D", d[dayOfWeek(blockDay.Date)]+" "+strconv.Itoa(int(blockDay.Date.Day)))
+		if len(trips) > tableLength {
+			tableLength = len(trips)
+		}
+	}
+	table.header = [][]string{names}
+	table.group = 5
+	for index := 0; index < tableLength; index++ {
+		var row []string
+		for _, trips := range days {
+			routeName, tripID, serviceID, scheduleTime, directionID := BlockItem(s.Feed, trips, len(trips), index)
+			row = append(row, routeName, tripID, serviceID, directionID, scheduleTime)
+		}
+		table.rows = append(table.rows, row)
+	}
+}
+
+// PrintBlockWeekPDF - prints the weekly block sheet as a paginated PDF
+func (s *Scheduler) PrintBlockWeekPDF(filename string, blockSchedule *BlockSchedule, blockID string, weekEnding gtfs.Date) error {
+	var blockDays []*BlockDay
+	for _, weekday := range weekOrder(len(blockSchedule.BlockDays)) {
+		blockDays = append(blockDays, blockSchedule.BlockDays[weekday])
+	}
+	return s.printPDF(filename, func(feedInfo *gtfs.FeedInfo, agency *gtfs.Agency) *pdfTable {
+		table := pdfTable{title: s.pdfTitle(feedInfo, agency, "Transit Block Schedule", "Block #"+blockID, WeekEnding(weekEnding))}
+		s.blockDaysTable(&table, agency, blockDays)
+		return &table
+	})
+}
+
+// PrintBlockSchedulePDF - prints the block sheet for a date range as a paginated PDF
+func (s *Scheduler) PrintBlockSchedulePDF(filename string, blockSchedule *BlockSchedule, blockID string) error {
+	return s.printPDF(filename, func(feedInfo *gtfs.FeedInfo, agency *gtfs.Agency) *pdfTable {
+		table := pdfTable{title: s.pdfTitle(feedInfo, agency, "Transit Block Schedule", "Block #"+blockID, blockDaysPeriod(blockSchedule.BlockDays))}
+		s.blockDaysTable(&table, agency, blockSchedule.BlockDays)
+		return &table
+	})
+}
+
+// PrintBlockCalendarPDF - prints the calendar of all blocks as a paginated PDF, one column group per date
+func (s *Scheduler) PrintBlockCalendarPDF(filename string, blockCalendar *BlockCalendar) error {
+	return s.printPDF(filename, func(feedInfo *gtfs.FeedInfo, agency *gtfs.Agency) *pdfTable {
+		d := s.daysOfWeekAbbrev(agency)
+		table := pdfTable{title: s.pdfTitle(feedInfo, agency, "Transit Block Calendar", "", blockDaysPeriod(blockCalendar.BlockDays))}
+		var days, names []string
+		tableLength := 0
+		for _, blockDay := range blockCalendar.BlockDays {
+			days = append(days, "", d[dayOfWeek(blockDay.Date)], strconv.Itoa(int(blockDay.Date.Day)))
+			names = append(names, "#", "Start", "End")
+			if len(blockDay.Blocks) > tableLength {
+				tableLength = len(blockDay.Blocks)
+			}
+		}
+		table.header = [][]string{days, names}
+		table.group = 3
+		for index := 0; index < tableLength; index++ {
+			var row []string
+			for _, blockDay := range blockCalendar.BlockDays {
+				blockID, startTime, endTime := BlockCalendarItem(s.Feed, blockDay.Blocks, len(blockDay.Blocks), index)
+				row = append(row, blockID, startTime, endTime)
+			}
+			table.rows = append(table.rows, row)
+		}
+		return &table
+	})
+}
+
+// PrintDeadheadWeekPDF - prints the weekly deadhead schedule as a paginated PDF
+func (s *Scheduler) PrintDeadheadWeekPDF(filename string, deadheadSchedule *DeadheadSchedule, weekEnding gtfs.Date) error {
+	return s.printPDF(filename, func(feedInfo *gtfs.FeedInfo, agency *gtfs.Agency) *pdfTable {
+		d := s.daysOfWeekAbbrev(agency)
+		table := pdfTable{title: s.pdfTitle(feedInfo, agency, "Transit Deadhead Schedule", "", WeekEnding(weekEnding))}
+		var names []string
+		tableLength := 0
+		for _, weekday := range weekOrder(len(deadheadSchedule.DeadheadDays)) {
+			deadheadDay := deadheadSchedule.DeadheadDays[weekday]
+			names = append(names, "Block", "#", "Trip ID", "S", "D", d[weekday]+" "+strconv.Itoa(int(deadheadDay.Date.Day)))
+			if len(deadheadDay.Trips) > tableLength {
+				tableLength = len(deadheadDay.Trips)
+			}
+		}
+		table.header = [][]string{names}
+		table.group = 6
+		for index := 0; index < tableLength; index++ {
+			var row []string
+			for _, weekday := range weekOrder(len(deadheadSchedule.DeadheadDays)) {
+				trips := deadheadSchedule.DeadheadDays[weekday].Trips
+				blockID, routeName, tripID, serviceID, scheduleTime, directionID := DeadheadItem(s.Feed, trips, len(trips), index)
+				row = append(row, blockID, routeName, tripID, serviceID, directionID, scheduleTime)
+			}
+			table.rows = append(table.rows, row)
+		}
+		return &table
+	})
+}
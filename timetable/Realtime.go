@@ -0,0 +1,410 @@
+package timetable
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	gtfsrt "github.com/thingful/transit_realtime"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// realtimeTimeout - how long a GTFS-RT feed may take to download
+const realtimeTimeout = 10 * time.Second
+
+// StopDelay - the realtime delay of a trip at one of its stops, against the scheduled arrival (or the departure if
+// the update only has a departure)
+type StopDelay struct {
+	StopID           string
+	StopSequence     int
+	DistanceTraveled float32
+	Scheduled        time.Time
+	Estimated        time.Time
+	Delay            int  // seconds late, negative if early
+	Propagated       bool // no update for this stop, the delay is carried over from an earlier stop
+	Skipped          bool // the vehicle will not call at the stop
+}
+
+// TripDelays - a TripUpdate matched to a trip of the feed, with the delay at each stop from the first update onwards
+type TripDelays struct {
+	TripID      string
+	RouteID     string
+	VehicleID   string
+	ServiceDate string
+	Start       time.Time // scheduled departure from the first stop of the trip
+	Timestamp   time.Time // when the update was measured, or the feed header time if the update has none
+	Canceled    bool
+	Stops       []*StopDelay
+}
+
+// ReadFeedMessage - reads a GTFS-RT feed from a file, or from an http:// or https:// URL
+func ReadFeedMessage(source string) (*gtfsrt.FeedMessage, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		data, err = download(source)
+	} else {
+		data, err = ioutil.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	message := &gtfsrt.FeedMessage{}
+	if err = proto.Unmarshal(data, message); err != nil {
+		return nil, fmt.Errorf("GTFS-RT %s: %v", source, err)
+	}
+	return message, nil
+}
+
+func download(url string) ([]byte, error) {
+	client := http.Client{Timeout: realtimeTimeout}
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GTFS-RT %s: %s", url, response.Status)
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+// MatchTripUpdates - matches the TripUpdates of the feed message to the scheduled trips by trip_id, and by start_time
+// for the trips generated from frequencies.txt, and computes the delay at each stop. Updates for trips that are not
+// in the feed, such as added trips, are counted as unmatched
+func (s *Scheduler) MatchTripUpdates(message *gtfsrt.FeedMessage) (trips []*TripDelays, unmatched int) {
+	headerTime := time.Unix(int64(message.GetHeader().GetTimestamp()), 0)
+	for _, entity := range message.GetEntity() {
+		update := entity.GetTripUpdate()
+		if update == nil || entity.GetIsDeleted() {
+			continue
+		}
+		timestamp := headerTime
+		if update.Timestamp != nil {
+			timestamp = time.Unix(int64(update.GetTimestamp()), 0)
+		}
+		tripDelays := s.matchTripUpdate(update, timestamp)
+		if tripDelays == nil {
+			unmatched++
+			continue
+		}
+		trips = append(trips, tripDelays)
+	}
+	sort.Slice(trips, func(i, j int) bool { return trips[i].Start.Before(trips[j].Start) })
+	log.Printf("TripUpdates at %s: %d matched, %d unmatched\n", headerTime.In(s.Location()).Format(time.RFC3339), len(trips), unmatched)
+	return trips, unmatched
+}
+
+func (s *Scheduler) matchTripUpdate(update *gtfsrt.TripUpdate, timestamp time.Time) *TripDelays {
+	descriptor := update.GetTrip()
+	// A trip with frequencies is matched by its start_time to the trip generated from it
+	trip := s.FindTrip(descriptor.GetTripId(), descriptor.GetStartTime())
+	if trip == nil || len(trip.Frequencies) > 0 || len(trip.StopTimes) == 0 {
+		return nil
+	}
+	date, ok := s.updateServiceDate(trip, descriptor.GetStartDate(), timestamp)
+	if !ok {
+		log.Printf("TripUpdate %s: no service on %s\n", trip.Id, descriptor.GetStartDate())
+		return nil
+	}
+	tripDelays := &TripDelays{
+		TripID:      trip.Id,
+		RouteID:     trip.Route.Id,
+		VehicleID:   update.GetVehicle().GetId(),
+		ServiceDate: Datestamp(date),
+		Start:       s.TripTime(trip, date, trip.StopTimes[0].Departure_time),
+		Timestamp:   timestamp,
+		Canceled:    descriptor.GetScheduleRelationship() == gtfsrt.TripDescriptor_CANCELED,
+		Stops:       []*StopDelay{},
+	}
+	if !tripDelays.Canceled {
+		tripDelays.Stops = s.stopDelays(trip, date, update)
+	}
+	return tripDelays
+}
+
+// updateServiceDate - the service date of the trip update, from its start_date or else the day on which the trip
+// runs nearest to the time of the update
+func (s *Scheduler) updateServiceDate(trip *gtfs.Trip, startDate string, timestamp time.Time) (gtfs.Date, bool) {
+	if startDate != "" {
+		date, err := ParseDate(startDate)
+		return date, err == nil && ServiceActiveOn(trip.Service, date)
+	}
+	loc := s.AgencyLocation(trip.Route.Agency)
+	today := ToDate(timestamp.In(loc).Date())
+	var best gtfs.Date
+	var bestDistance time.Duration
+	found := false
+	for days := -1; days <= 1; days++ {
+		date := DateAdd(today, days)
+		if !ServiceActiveOn(trip.Service, date) {
+			continue
+		}
+		first := s.TripTime(trip, date, trip.StopTimes[0].Departure_time)
+		last := s.TripTime(trip, date, trip.StopTimes[len(trip.StopTimes)-1].Arrival_time)
+		var distance time.Duration
+		if timestamp.Before(first) {
+			distance = first.Sub(timestamp)
+		} else if timestamp.After(last) {
+			distance = timestamp.Sub(last)
+		}
+		if !found || distance < bestDistance {
+			best, bestDistance, found = date, distance, true
+		}
+	}
+	return best, found
+}
+
+// stopDelays - the delay at each stop of the trip from its first stop time update. Stops without an update take
+// the delay of the previous update, as the GTFS-RT spec propagates it, until an update has NO_DATA
+func (s *Scheduler) stopDelays(trip *gtfs.Trip, date gtfs.Date, update *gtfsrt.TripUpdate) (delays []*StopDelay) {
+	bySequence := make(map[uint32]*gtfsrt.TripUpdate_StopTimeUpdate)
+	byStopID := make(map[string]*gtfsrt.TripUpdate_StopTimeUpdate)
+	for _, stopTimeUpdate := range update.GetStopTimeUpdate() {
+		if stopTimeUpdate.StopSequence != nil {
+			bySequence[stopTimeUpdate.GetStopSequence()] = stopTimeUpdate
+		} else if _, ok := byStopID[stopTimeUpdate.GetStopId()]; !ok {
+			byStopID[stopTimeUpdate.GetStopId()] = stopTimeUpdate
+		}
+	}
+	var delay time.Duration
+	known := false
+	for _, stopTime := range trip.StopTimes {
+		stopTimeUpdate, ok := bySequence[uint32(stopTime.Sequence)]
+		if !ok {
+			stopTimeUpdate, ok = byStopID[stopTime.Stop.Id]
+		}
+		stopDelay := &StopDelay{
+			StopID:           stopTime.Stop.Id,
+			StopSequence:     stopTime.Sequence,
+			DistanceTraveled: stopTime.Shape_dist_traveled,
+			Scheduled:        s.TripTime(trip, date, stopTime.Arrival_time),
+			Propagated:       !ok,
+		}
+		if ok {
+			switch stopTimeUpdate.GetScheduleRelationship() {
+			case gtfsrt.TripUpdate_StopTimeUpdate_NO_DATA:
+				known = false
+				continue
+			case gtfsrt.TripUpdate_StopTimeUpdate_SKIPPED:
+				stopDelay.Skipped = true
+			default:
+				event := stopTimeUpdate.GetArrival()
+				if event == nil {
+					event = stopTimeUpdate.GetDeparture()
+					stopDelay.Scheduled = s.TripTime(trip, date, stopTime.Departure_time)
+				}
+				if eventDelay, eventOK := stopTimeEventDelay(event, stopDelay.Scheduled); eventOK {
+					delay, known = eventDelay, true
+				}
+			}
+		}
+		if !known {
+			continue
+		}
+		stopDelay.Delay = int(delay / time.Second)
+		stopDelay.Estimated = stopDelay.Scheduled.Add(delay)
+		delays = append(delays, stopDelay)
+	}
+	return delays
+}
+
+// stopTimeEventDelay - the delay of an arrival or departure event, from its absolute time if it has one
+func stopTimeEventDelay(event *gtfsrt.TripUpdate_StopTimeEvent, scheduled time.Time) (time.Duration, bool) {
+	switch {
+	case event == nil:
+		return 0, false
+	case event.Time != nil:
+		return time.Unix(event.GetTime(), 0).Sub(scheduled), true
+	case event.Delay != nil:
+		return time.Duration(event.GetDelay()) * time.Second, true
+	}
+	return 0, false
+}
+
+// occupancyPercentageField - the field number of occupancy_percentage in VehiclePosition, which is newer than the
+// generated transit_realtime bindings and so is read from the unrecognized fields
+const occupancyPercentageField = 12
+
+// occupancyEstimates - the percentage of the capacity taken as the load of each occupancy_status when the vehicle
+// does not report its occupancy_percentage
+var occupancyEstimates = map[gtfsrt.VehiclePosition_OccupancyStatus]int{
+	gtfsrt.VehiclePosition_EMPTY:                      0,
+	gtfsrt.VehiclePosition_MANY_SEATS_AVAILABLE:       25,
+	gtfsrt.VehiclePosition_FEW_SEATS_AVAILABLE:        60,
+	gtfsrt.VehiclePosition_STANDING_ROOM_ONLY:         85,
+	gtfsrt.VehiclePosition_CRUSHED_STANDING_ROOM_ONLY: 100,
+	gtfsrt.VehiclePosition_FULL:                       100,
+	gtfsrt.VehiclePosition_NOT_ACCEPTING_PASSENGERS:   100,
+}
+
+// VehicleState - the last VehiclePosition of a vehicle on a trip, together with the block & route of the trip
+type VehicleState struct {
+	VehicleID           string
+	Label               string
+	TripID              string // empty if the vehicle is not serving a trip of the feed
+	RouteID             string
+	BlockID             string
+	ServiceDate         string
+	Lat                 float32
+	Lng                 float32
+	Bearing             float32
+	StopID              string
+	StopSequence        int
+	CurrentStatus       string // INCOMING_AT, STOPPED_AT or IN_TRANSIT_TO the stop
+	CongestionLevel     string
+	OccupancyStatus     string
+	OccupancyPercentage int  // reported, or estimated from the occupancy_status, or -1 if neither is known
+	OccupancyEstimated  bool // the percentage is estimated from the occupancy_status
+	Timestamp           time.Time
+}
+
+// Key - the vehicle & trip the state is kept under
+func (v *VehicleState) Key() string {
+	return v.VehicleID + "/" + v.TripID
+}
+
+// MatchVehiclePositions - the state of each vehicle of the VehiclePositions feed, matched to the scheduled trips by
+// trip_id, and by start_time to the trips generated from frequencies.txt. Vehicles on trips that are not in the feed
+// keep their trip_id & route_id but have no block
+func (s *Scheduler) MatchVehiclePositions(message *gtfsrt.FeedMessage) (vehicles []*VehicleState) {
+	headerTime := time.Unix(int64(message.GetHeader().GetTimestamp()), 0)
+	matched := 0
+	for _, entity := range message.GetEntity() {
+		position := entity.GetVehicle()
+		if position == nil || entity.GetIsDeleted() {
+			continue
+		}
+		vehicle := &VehicleState{
+			VehicleID:           position.GetVehicle().GetId(),
+			Label:               position.GetVehicle().GetLabel(),
+			TripID:              position.GetTrip().GetTripId(),
+			RouteID:             position.GetTrip().GetRouteId(),
+			Lat:                 position.GetPosition().GetLatitude(),
+			Lng:                 position.GetPosition().GetLongitude(),
+			Bearing:             position.GetPosition().GetBearing(),
+			StopID:              position.GetStopId(),
+			StopSequence:        int(position.GetCurrentStopSequence()),
+			CurrentStatus:       position.GetCurrentStatus().String(),
+			OccupancyPercentage: -1,
+			Timestamp:           headerTime,
+		}
+		if vehicle.VehicleID == "" {
+			vehicle.VehicleID = entity.GetId()
+		}
+		if position.Timestamp != nil {
+			vehicle.Timestamp = time.Unix(int64(position.GetTimestamp()), 0)
+		}
+		if position.CongestionLevel != nil {
+			vehicle.CongestionLevel = position.GetCongestionLevel().String()
+		}
+		if position.OccupancyStatus != nil {
+			vehicle.OccupancyStatus = position.GetOccupancyStatus().String()
+			vehicle.OccupancyPercentage, vehicle.OccupancyEstimated = occupancyEstimates[position.GetOccupancyStatus()], true
+		}
+		if percentage, ok := occupancyPercentage(position); ok {
+			vehicle.OccupancyPercentage, vehicle.OccupancyEstimated = percentage, false
+		}
+		if trip := s.FindTrip(vehicle.TripID, position.GetTrip().GetStartTime()); trip != nil && len(trip.Frequencies) == 0 && len(trip.StopTimes) > 0 {
+			vehicle.TripID = trip.Id
+			vehicle.RouteID = trip.Route.Id
+			vehicle.BlockID = trip.Block_id
+			if date, ok := s.updateServiceDate(trip, position.GetTrip().GetStartDate(), vehicle.Timestamp); ok {
+				vehicle.ServiceDate = Datestamp(date)
+			}
+			matched++
+		}
+		vehicles = append(vehicles, vehicle)
+	}
+	sort.Slice(vehicles, func(i, j int) bool {
+		if vehicles[i].BlockID != vehicles[j].BlockID {
+			return vehicles[i].BlockID < vehicles[j].BlockID
+		}
+		return vehicles[i].Key() < vehicles[j].Key()
+	})
+	log.Printf("VehiclePositions at %s: %d vehicles, %d on scheduled trips\n", headerTime.In(s.Location()).Format(time.RFC3339), len(vehicles), matched)
+	return vehicles
+}
+
+// occupancyPercentage - the occupancy_percentage of the vehicle position, if it has one
+func occupancyPercentage(position *gtfsrt.VehiclePosition) (int, bool) {
+	data := position.XXX_unrecognized
+	for len(data) > 0 {
+		key, n := proto.DecodeVarint(data)
+		if n == 0 {
+			return 0, false
+		}
+		data = data[n:]
+		var size uint64
+		switch key & 7 {
+		case proto.WireVarint:
+			value, n := proto.DecodeVarint(data)
+			if n == 0 {
+				return 0, false
+			}
+			if key>>3 == occupancyPercentageField {
+				return int(value), true
+			}
+			size = uint64(n)
+		case proto.WireFixed64:
+			size = 8
+		case proto.WireFixed32:
+			size = 4
+		case proto.WireBytes:
+			length, n := proto.DecodeVarint(data)
+			if n == 0 {
+				return 0, false
+			}
+			size = uint64(n) + length
+		default:
+			return 0, false
+		}
+		if size > uint64(len(data)) {
+			return 0, false
+		}
+		data = data[size:]
+	}
+	return 0, false
+}
+
+// RunningTrip - a trip of the feed on one of its service dates
+type RunningTrip struct {
+	Trip        *gtfs.Trip
+	Frequency   *Frequency // the frequencies.txt entry the trip was generated from, nil for a trip of trips.txt
+	ServiceDate gtfs.Date
+	Start       time.Time // scheduled departure from the first stop
+	End         time.Time // scheduled arrival at the last stop
+}
+
+// RunningTrips - the trips scheduled to be between their first departure and last arrival at the instant, on the
+// service day of the instant or the day before for trips running past 24:00, ordered by start
+func (s *Scheduler) RunningTrips(at time.Time) (trips []*RunningTrip) {
+	for _, trip := range s.operatingTrips() {
+		if len(trip.StopTimes) == 0 {
+			continue
+		}
+		today := ToDate(at.In(s.AgencyLocation(trip.Route.Agency)).Date())
+		for days := -1; days <= 0; days++ {
+			date := DateAdd(today, days)
+			if !ServiceActiveOn(trip.Service, date) {
+				continue
+			}
+			start := s.TripTime(trip, date, trip.StopTimes[0].Departure_time)
+			end := s.TripTime(trip, date, trip.StopTimes[len(trip.StopTimes)-1].Arrival_time)
+			if !at.Before(start) && !at.After(end) {
+				trips = append(trips, &RunningTrip{trip, s.FrequencyOf(trip), date, start, end})
+			}
+		}
+	}
+	sort.Slice(trips, func(i, j int) bool {
+		if !trips[i].Start.Equal(trips[j].Start) {
+			return trips[i].Start.Before(trips[j].Start)
+		}
+		return trips[i].Trip.Id < trips[j].Trip.Id
+	})
+	return trips
+}
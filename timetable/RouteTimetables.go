@@ -0,0 +1,205 @@
+package timetable
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RouteTrip - a column of a RouteTimetable, holding the trip stop time at each timepoint row, or nil where the trip
+// does not call at the timepoint
+type RouteTrip struct {
+	Trip  *gtfs.Trip
+	Times []*gtfs.StopTime
+}
+
+// RouteTimetable - the public timetable of a route in one direction on a service date, with the timepoint stops as
+// rows and the trips as columns
+type RouteTimetable struct {
+	Route     *gtfs.Route
+	Direction int
+	Date      gtfs.Date
+	Stops     []*gtfs.Stop
+	Trips     []*RouteTrip
+}
+
+// timepoints - the stop times of the trip that are timepoints, or else its first & last stop times
+func timepoints(trip *gtfs.Trip) (stopTimes []*gtfs.StopTime) {
+	for i := range trip.StopTimes {
+		if trip.StopTimes[i].Timepoint {
+			stopTimes = append(stopTimes, &trip.StopTimes[i])
+		}
+	}
+	if len(stopTimes) == 0 && len(trip.StopTimes) != 0 {
+		stopTimes = append(stopTimes, &trip.StopTimes[0])
+		if len(trip.StopTimes) > 1 {
+			stopTimes = append(stopTimes, &trip.StopTimes[len(trip.StopTimes)-1])
+		}
+	}
+	return stopTimes
+}
+
+// mergeStopPattern - merges the stops of a trip pattern into the ordered stop list. Each stop already in the list is
+// matched after the previous match, and each new stop is inserted after the previous stop of the pattern
+func mergeStopPattern(stops []*gtfs.Stop, pattern []*gtfs.StopTime) []*gtfs.Stop {
+	position := -1
+	for _, stopTime := range pattern {
+		found := -1
+		for i := position + 1; i < len(stops); i++ {
+			if stops[i].Id == stopTime.Stop.Id {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			found = position + 1
+			stops = append(stops, nil)
+			copy(stops[found+1:], stops[found:])
+			stops[found] = stopTime.Stop
+		}
+		position = found
+	}
+	return stops
+}
+
+// routeTrip - places the timepoints of the trip on the rows of the ordered stop list
+func routeTrip(trip *gtfs.Trip, stops []*gtfs.Stop) *RouteTrip {
+	routeTrip := RouteTrip{Trip: trip, Times: make([]*gtfs.StopTime, len(stops))}
+	position := -1
+	for _, stopTime := range timepoints(trip) {
+		for i := position + 1; i < len(stops); i++ {
+			if stops[i].Id == stopTime.Stop.Id {
+				routeTrip.Times[i] = stopTime
+				position = i
+				break
+			}
+		}
+	}
+	return &routeTrip
+}
+
+// CreateRouteTimetables - creates the public timetable of the route for each direction of its trips starting on the
+// operating day of the date, in the same way as CreateTimetable, so trips before StartOfDay belong to the
+// previous day and trips of the previous service day past 24:00 are included
+func (s *Scheduler) CreateRouteTimetables(routeID string, date gtfs.Date) (routeTimetables []*RouteTimetable) {
+	firstStops := make(map[string]*gtfs.Stop)
+	for _, trip := range s.Feed.Trips {
+		if trip.Route.Id == routeID && len(trip.StopTimes) != 0 {
+			firstStops[trip.StopTimes[0].Stop.Id] = trip.StopTimes[0].Stop
+		}
+	}
+	var starts StopTimes
+	for _, stopTime := range s.stopTimesOn(firstStops, date) {
+		first := stopTime.Trip.StopTimes[0]
+		if stopTime.Trip.Route.Id == routeID && stopTime.Stop == first.Stop && stopTime.DepartureTime == first.Departure_time {
+			starts = append(starts, stopTime)
+		}
+	}
+	sort.SliceStable(starts, func(i, j int) bool { return starts[i].departureSeconds(date) < starts[j].departureSeconds(date) })
+	directions := make(map[int][]*gtfs.Trip)
+	for _, start := range starts {
+		trip := start.Trip
+		directions[int(trip.Direction_id)] = append(directions[int(trip.Direction_id)], trip)
+	}
+	for direction, trips := range directions {
+		// Merge the longest patterns first, so that the shorter ones fit within them
+		patterns := append([]*gtfs.Trip(nil), trips...)
+		sort.SliceStable(patterns, func(i, j int) bool { return len(timepoints(patterns[i])) > len(timepoints(patterns[j])) })
+		routeTimetable := RouteTimetable{Route: s.Feed.Routes[routeID], Direction: direction, Date: date}
+		for _, trip := range patterns {
+			routeTimetable.Stops = mergeStopPattern(routeTimetable.Stops, timepoints(trip))
+		}
+		for _, trip := range trips {
+			routeTimetable.Trips = append(routeTimetable.Trips, routeTrip(trip, routeTimetable.Stops))
+		}
+		log.Printf("Route %s direction %d: %d timepoints, %d trips\n", routeID, direction, len(routeTimetable.Stops), len(routeTimetable.Trips))
+		routeTimetables = append(routeTimetables, &routeTimetable)
+	}
+	sort.Slice(routeTimetables, func(i, j int) bool { return routeTimetables[i].Direction < routeTimetables[j].Direction })
+	return routeTimetables
+}
+
+// Destination - the most common headsign of the route timetable trips, or else the direction
+func (r *RouteTimetable) Destination() (destination string) {
+	counts := make(map[string]int)
+	for _, routeTrip := range r.Trips {
+		if headsign := routeTrip.Trip.Headsign; headsign != "" {
+			counts[headsign]++
+			if counts[headsign] > counts[destination] || (counts[headsign] == counts[destination] && headsign < destination) {
+				destination = headsign
+			}
+		}
+	}
+	if destination == "" {
+		destination = "Direction " + strconv.Itoa(r.Direction)
+	}
+	return destination
+}
+
+// TripName - the trip_short_name of the trip column, or else its trip ID
+func (r *RouteTrip) TripName() string {
+	if r.Trip.Short_name != "" {
+		return r.Trip.Short_name
+	}
+	return r.Trip.Id
+}
+
+// Cell - the departure time of the trip at the timepoint row, or "-" where the trip does not call
+func (r *RouteTrip) Cell(row int) string {
+	if r.Times[row] == nil {
+		return "-"
+	}
+	return Timestamp(r.Times[row].Departure_time, hhmm)
+}
+
+// routeName - the route short & long names
+func routeName(route *gtfs.Route) string {
+	return strings.TrimSpace(route.Short_name + " " + route.Long_name)
+}
+
+// routeDate - the weekday & date of the route timetable
+func routeDate(date gtfs.Date) string {
+	return toTime(date).Format(layoutUS)
+}
+
+// PrintRouteTimetableCSV - prints the route timetable with the timepoint stops as rows and the trips as columns
+func (s *Scheduler) PrintRouteTimetableCSV(filename string, routeTimetable *RouteTimetable) (err error) {
+	feed := s.Feed
+	for _, feedInfo := range feed.FeedInfos {
+		title := feedInfo.Publisher_name + "\n" + "Version:" + feedInfo.Version + "\n"
+		for _, agency := range s.agencies() {
+			file, err := s.createFile(agency, filename)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			start, end := getFeedDateRange(feed, 0)
+			header := fmt.Sprintf("%s\nRoute Timetable\nRoute %s - %s\nFrom: %s - To: %s\n%s\nStop,", agency.Name, routeName(routeTimetable.Route), routeTimetable.Destination(), Datestamp(start), Datestamp(end), routeDate(routeTimetable.Date))
+			for _, routeTrip := range routeTimetable.Trips {
+				header += routeTrip.TripName() + ","
+			}
+			header += "\n"
+			file.WriteString(title + header)
+			log.Printf(title + header)
+
+			for row, stop := range routeTimetable.Stops {
+				line := strings.Replace(stop.Name, ",", " ", -1) + ","
+				for _, routeTrip := range routeTimetable.Trips {
+					line += routeTrip.Cell(row) + ","
+				}
+				line += "\n"
+				_, err = file.WriteString(line)
+				log.Printf("%s", line)
+				if err != nil {
+					return err
+				}
+			}
+			file.Sync()
+		}
+	}
+	return err
+}
@@ -0,0 +1,175 @@
+package timetable
+
+import (
+	"github.com/patrickbr/gtfsparser"      //"github.com/geops/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Scheduler - a parsed GTFS feed together with the service & block tables derived from it
+type Scheduler struct {
+	Feed              *gtfsparser.Feed
+	Servicetables     []*Servicetable
+	Blocktables       []*Blocktable
+	ServiceExceptions []*ServiceException
+	StopSchedules     Schedules
+	BlockSchedules    []*BlockSchedule
+	OutputDir         string    // directory the printed reports are written to
+	AgencyID          string    // restricts the printed reports to a single agency
+	Lang              string    // overrides the agency language of the printed reports
+	NextDayDisplay    bool      // shows stop times past 24:00 under the next calendar day instead of their service day
+	StartOfDay        gtfs.Time // when one operating day ends and the next begins, DefaultStartOfDay from NewScheduler
+	TemplateDir       string    // directory of the HTML page templates, DefaultTemplateDir if empty
+	Paper             PaperSize // page size of the PDF reports, A4 if zero
+	Garage            *Garage   // where the blocks pull out from & pull in to, no pull-outs or pull-ins if nil
+	DeadheadSpeed     float64   // average deadhead speed in km/h, DefaultDeadheadSpeed if zero
+	DeadheadEstimate  string    // StraightLineEstimate or ShapeEstimate, straight line if empty
+	shapeLegs         map[string]deadheadLeg
+	shapeLegsLock     sync.Mutex
+	frequencies       map[*gtfs.Trip]*Frequency  // the frequencies.txt entry of each trip generated from one
+	frequencyTrips    map[string]*gtfs.Trip      // the trips generated from frequencies.txt by their trip_id@HH:MM:SS
+	stopSequenceRows  map[string]stopSequenceRow // the first stop_times.txt row out of stop_sequence order of each trip
+	locations         map[string]*time.Location
+}
+
+// NewScheduler - builds the service & block tables for an already parsed feed
+func NewScheduler(feed *gtfsparser.Feed) (s *Scheduler) {
+	s = &Scheduler{Feed: feed, StartOfDay: secondsToTime(int(DefaultStartOfDay / time.Second))}
+	s.loadLocations()
+	var templates []*gtfs.Trip
+	for _, trip := range feed.Trips {
+		sort.Sort(trip.StopTimes)
+		if len(trip.Frequencies) > 0 {
+			templates = append(templates, trip)
+			continue
+		}
+		s.Servicetables = addTripToServicetable(s.Servicetables, trip)
+		s.Blocktables = addTripToBlocktable(s.Blocktables, trip)
+	}
+	s.expandFrequencies(templates)
+	for _, service := range feed.Services {
+		log.Println("Service: ", service.Id, Datestamp(service.Start_date), Datestamp(service.End_date))
+		log.Println("First: ", Datestamp(service.GetFirstDefinedDate()), "Last: ", Datestamp(service.GetLastDefinedDate()))
+		for k2, v := range service.Exceptions {
+			s.addToExceptionTable(service, &k2, Exception(v))
+			log.Println("Exception:", service.Id, Datestamp(k2), ExceptionType(Exception(v)))
+		}
+	}
+	return s
+}
+
+// Load - parses the GTFS zip file (or folder) and builds a Scheduler from it
+func Load(zipFile string) (s *Scheduler, err error) {
+	feed := gtfsparser.NewFeed()
+	if err = feed.Parse(zipFile); err != nil {
+		return nil, err
+	}
+	s = NewScheduler(feed)
+	if s.stopSequenceRows, err = readStopSequenceOrder(zipFile); err != nil {
+		log.Println("Stop sequence order:", err)
+	}
+	return s, nil
+}
+
+// AddToStopSchedule -
+func (s *Scheduler) AddToStopSchedule(route *gtfs.Route, stopTime StopTime) {
+	for _, v := range s.StopSchedules {
+		if v.Route.Id == route.Id {
+			v.StopTimes = append(v.StopTimes, &stopTime)
+			return
+		}
+	}
+	stopSchedule := StopSchedule{}
+	stopSchedule.Route = route
+	stopSchedule.StopTimes = append(stopSchedule.StopTimes, &stopTime)
+	s.StopSchedules = append(s.StopSchedules, &stopSchedule)
+}
+
+// GetStopID -
+func (s *Scheduler) GetStopID(stopCode string) string {
+	for _, v := range s.Feed.Stops {
+		if stopCode == v.Code {
+			return v.Id
+		}
+	}
+	return ""
+}
+
+// FindStop - returns the stop with the specified stop_code, or nil if there is none
+func (s *Scheduler) FindStop(stopCode string) (stop *gtfs.Stop) {
+	for _, v := range s.Feed.Stops {
+		if stopCode == v.Code {
+			return v
+		}
+	}
+	return nil
+}
+
+// FindStops - returns the stops with the specified stop_codes, or else the first stop_code that has no stop
+func (s *Scheduler) FindStops(stopCodes []string) (stops []*gtfs.Stop, missing string) {
+	for _, stopCode := range stopCodes {
+		stop := s.FindStop(stopCode)
+		if stop == nil {
+			return nil, stopCode
+		}
+		stops = append(stops, stop)
+	}
+	return stops, ""
+}
+
+// StationStops - returns the stops & platforms whose parent_station is the specified stop_id, ordered by platform
+func (s *Scheduler) StationStops(stationID string) (stops []*gtfs.Stop) {
+	for _, v := range s.Feed.Stops {
+		if v.Location_type == 0 && v.Parent_station != nil && v.Parent_station.Id == stationID {
+			stops = append(stops, v)
+		}
+	}
+	sort.Slice(stops, func(i, j int) bool {
+		if Platform(stops[i]) != Platform(stops[j]) {
+			return Platform(stops[i]) < Platform(stops[j])
+		}
+		return stops[i].Id < stops[j].Id
+	})
+	return stops
+}
+
+// AllStops - returns the stops & platforms of the feed, leaving out stations, entrances and other locations
+func (s *Scheduler) AllStops() (stops []*gtfs.Stop) {
+	for _, v := range s.Feed.Stops {
+		if v.Location_type == 0 {
+			stops = append(stops, v)
+		}
+	}
+	return stops
+}
+
+// FindBlocktable - returns the Blocktable with the specified block_id, or nil if there is none
+func (s *Scheduler) FindBlocktable(blockID string) *Blocktable {
+	for _, blocktable := range s.Blocktables {
+		if blocktable.BlockID == blockID {
+			return blocktable
+		}
+	}
+	return nil
+}
+
+// agencies - returns the feed agencies selected for output, ordered by agency ID
+func (s *Scheduler) agencies() (agencies []*gtfs.Agency) {
+	for _, agency := range s.Feed.Agencies {
+		if s.AgencyID == "" || s.AgencyID == agency.Id {
+			agencies = append(agencies, agency)
+		}
+	}
+	sort.Slice(agencies, func(i, j int) bool { return agencies[i].Id < agencies[j].Id })
+	return agencies
+}
+
+// createFile - creates the named output file for the agency within the output directory
+func (s *Scheduler) createFile(agency *gtfs.Agency, filename string) (*os.File, error) {
+	return os.Create(filepath.Join(s.OutputDir, agency.Name+"-"+filename))
+}
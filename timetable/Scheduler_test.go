@@ -0,0 +1,37 @@
+package timetable
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// writeFeed - writes a minimal GTFS feed folder, with two trips T1 & T2 of route R in block B1 running every day of
+// 2026 and stops S1, S2 & S3, in which the files given replace or add to those of the minimal feed. Returns the folder
+func writeFeed(t *testing.T, replace map[string]string) string {
+	dir, err := ioutil.TempDir("", "gtfs-parse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"agency.txt": "agency_id,agency_name,agency_url,agency_timezone\n" +
+			"A,Test Transit,http://example.com,UTC\n",
+		"stops.txt": "stop_id,stop_code,stop_name,stop_lat,stop_lon\n" +
+			"S1,101,First,36.0,-116.0\nS2,102,Second,36.1,-116.1\nS3,103,Third,36.2,-116.2\n",
+		"routes.txt": "route_id,agency_id,route_short_name,route_long_name,route_type\n" +
+			"R,A,1,Test,3\n",
+		"trips.txt": "route_id,service_id,trip_id,direction_id,block_id\n" +
+			"R,WK,T1,0,B1\nR,WK,T2,0,B1\n",
+		"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\n" +
+			"WK,1,1,1,1,1,1,1,20260101,20261231\n",
+	}
+	for name, content := range replace {
+		files[name] = content
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
@@ -0,0 +1,144 @@
+package timetable
+
+import (
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"sort"
+)
+
+// Servicetable -
+type Servicetable struct {
+	Service *gtfs.Service
+	Trips   []*gtfs.Trip
+}
+
+func addTripToServicetable(inputtables []*Servicetable, trip *gtfs.Trip) (outputtables []*Servicetable) {
+	for _, v := range inputtables {
+		if v.Service.Id == trip.Service.Id {
+			v.Trips = append(v.Trips, trip)
+			return inputtables
+		}
+	}
+	servicetable := Servicetable{}
+	servicetable.Service = trip.Service
+	servicetable.Trips = append(servicetable.Trips, trip)
+	outputtables = append(inputtables, &servicetable)
+	return outputtables
+}
+
+// servicetable - returns the Servicetable holding the trips of the specified service
+func (s *Scheduler) servicetable(serviceID string) *Servicetable {
+	for _, v := range s.Servicetables {
+		if v.Service.Id == serviceID {
+			return v
+		}
+	}
+	return nil
+}
+
+func emptyDaymap(service *gtfs.Service) bool {
+	for i := 0; i < len(service.Daymap); i++ {
+		if service.Daymap[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ServiceDay - each day can be comprised a series of Service specifications
+type ServiceDay []*gtfs.Service
+
+// ServiceWeek - each service week consists of 7 days of daily Service specifications
+type ServiceWeek [7]ServiceDay
+
+// ServiceActiveOn - returns true if the service operates on the specified date. A calendar_dates.txt exception for
+// that exact date takes precedence, otherwise the calendar.txt Daymap applies within the service start/end date range
+func ServiceActiveOn(service *gtfs.Service, date gtfs.Date) bool {
+	switch Exception(service.Exceptions[date]) {
+	case Add:
+		return true
+	case Delete:
+		return false
+	}
+	if emptyDaymap(service) || !service.Daymap[dayOfWeek(date)] {
+		return false
+	}
+	return !DateBefore(date, service.Start_date) && !DateBefore(service.End_date, date)
+}
+
+// ServicesOn - resolves the services operating on the specified calendar date, ordered by service ID
+func (s *Scheduler) ServicesOn(date gtfs.Date) []*gtfs.Service {
+	var services ServiceDay
+	for _, service := range s.Feed.Services {
+		if ServiceActiveOn(service, date) {
+			services = append(services, service)
+		}
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Id < services[j].Id })
+	return services
+}
+
+// ServiceWeekEnding - resolves the services operating on each weekday of the week ending on the specified date
+func (s *Scheduler) ServiceWeekEnding(weekEnding gtfs.Date) (week ServiceWeek) {
+	for weekday := 0; weekday < len(week); weekday++ {
+		week[weekday] = s.ServicesOn(weekDate(weekEnding, weekday))
+	}
+	return week
+}
+
+// ServiceDelete -
+func ServiceDelete(input ServiceDay, serviceID string) (output ServiceDay) {
+	output = input
+	for i, v := range output {
+		if serviceID == v.Id {
+			// Remove the element at index i from output.
+			copy(output[i:], output[i+1:])  // Shift output[i+1:] left one index.
+			output[len(output)-1] = nil     // Erase last element (write zero value).
+			output = output[:len(output)-1] // Truncate slice.
+			break
+		}
+	}
+	return output
+}
+
+// Exception -
+type Exception int
+
+// TripDirection -
+type TripDirection int
+
+const (
+	// Add - add service exception
+	Add Exception = iota + 1
+	// Delete - delete service exception
+	Delete
+	// Inbound -
+	Inbound TripDirection = iota
+	// Outbound -
+	Outbound
+)
+
+// ExceptionType -
+func ExceptionType(exception Exception) (value string) {
+	switch exception {
+	case 1:
+		value = "Add"
+	case 2:
+		value = "Delete"
+	}
+	return value
+}
+
+// ServiceException -
+type ServiceException struct {
+	ExType  Exception
+	Service *gtfs.Service
+	Date    gtfs.Date
+}
+
+func (s *Scheduler) addToExceptionTable(service *gtfs.Service, date *gtfs.Date, exception Exception) {
+	serviceException := ServiceException{}
+	serviceException.ExType = exception
+	serviceException.Service = service
+	serviceException.Date = *date
+	s.ServiceExceptions = append(s.ServiceExceptions, &serviceException)
+}
@@ -0,0 +1,48 @@
+package timetable
+
+import (
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"testing"
+	"time"
+)
+
+func TestServiceActiveOn(t *testing.T) {
+	weekdays := &gtfs.Service{
+		Id:         "WD",
+		Daymap:     [7]bool{false, true, true, true, true, true, false},
+		Start_date: ToDate(2026, time.January, 1),
+		End_date:   ToDate(2026, time.December, 31),
+		Exceptions: map[gtfs.Date]int8{
+			ToDate(2026, time.March, 2):   int8(Delete), // a Monday
+			ToDate(2026, time.March, 7):   int8(Add),    // a Saturday
+			ToDate(2027, time.January, 4): int8(Add),    // a Monday after the end date
+		},
+	}
+	datesOnly := &gtfs.Service{
+		Id:         "DATES",
+		Exceptions: map[gtfs.Date]int8{ToDate(2026, time.May, 1): int8(Add)},
+	}
+	tests := []struct {
+		name    string
+		service *gtfs.Service
+		date    gtfs.Date
+		want    bool
+	}{
+		{"weekday", weekdays, ToDate(2026, time.March, 9), true},
+		{"weekend", weekdays, ToDate(2026, time.March, 14), false},
+		{"weekday removed", weekdays, ToDate(2026, time.March, 2), false},
+		{"weekend added", weekdays, ToDate(2026, time.March, 7), true},
+		{"start date", weekdays, ToDate(2026, time.January, 1), true},
+		{"end date", weekdays, ToDate(2026, time.December, 31), true},
+		{"before start date", weekdays, ToDate(2025, time.December, 31), false},
+		{"after end date", weekdays, ToDate(2027, time.January, 11), false},
+		{"added after end date", weekdays, ToDate(2027, time.January, 4), true},
+		{"calendar_dates.txt only, added", datesOnly, ToDate(2026, time.May, 1), true},
+		{"calendar_dates.txt only, other date", datesOnly, ToDate(2026, time.May, 4), false},
+	}
+	for _, test := range tests {
+		if got := ServiceActiveOn(test.service, test.date); got != test.want {
+			t.Errorf("%s: ServiceActiveOn(%s, %s) = %t, want %t", test.name, test.service.Id, Datestamp(test.date), got, test.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package timetable
+
+import (
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"math"
+	"sort"
+)
+
+// earthRadius - the mean radius of the earth in km
+const earthRadius = 6371.0
+
+// StraightLineDistance - the great circle distance in km between two WGS-84 positions
+func StraightLineDistance(lat1, lon1, lat2, lon2 float32) float64 {
+	rlat1, rlat2 := radians(lat1), radians(lat2)
+	dLat, dLon := radians(lat2-lat1), radians(lon2-lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rlat1)*math.Cos(rlat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+func radians(degrees float32) float64 {
+	return float64(degrees) * math.Pi / 180
+}
+
+// StopPointDistances - the distance along the trip of each stop point, from shape_dist_traveled if the feed has it,
+// or else the sum of the straight line km between the stops. shapeDist is true for shape_dist_traveled, whose units
+// are those of the feed
+func StopPointDistances(stopPoints []*StopPoint) (distances []float64, shapeDist bool) {
+	for _, stopPoint := range stopPoints {
+		shapeDist = shapeDist || stopPoint.DistanceTraveled > 0
+	}
+	total := 0.0
+	for i, stopPoint := range stopPoints {
+		if shapeDist {
+			distances = append(distances, float64(stopPoint.DistanceTraveled))
+			continue
+		}
+		if i > 0 {
+			previous := stopPoints[i-1]
+			total += StraightLineDistance(previous.Lat, previous.Lng, stopPoint.Lat, stopPoint.Lng)
+		}
+		distances = append(distances, total)
+	}
+	return distances, shapeDist
+}
+
+// tripPath - the geometry of a trip: the points of its shape, or else its stops, with the distance along the path of
+// each point and each stop time
+type tripPath struct {
+	lats, lons []float32
+	points     []float64 // distance of each point
+	stops      []float64 // distance of each stop time
+}
+
+// newTripPath - the path of the trip along its shape. Distances come from shape_dist_traveled when both the shape
+// points and the stop times have it, and otherwise each stop is placed at the nearest following shape point and the
+// distances are straight line km. Trips without a shape follow straight lines between their stops
+func newTripPath(trip *gtfs.Trip) *tripPath {
+	if trip.Shape == nil || len(trip.Shape.Points) < 2 {
+		return newTripPathKm(trip)
+	}
+	shapeDist := true
+	for _, point := range trip.Shape.Points {
+		shapeDist = shapeDist && point.Has_dist
+	}
+	for _, stopTime := range trip.StopTimes {
+		shapeDist = shapeDist && stopTime.Has_dist
+	}
+	return shapePath(trip, shapeDist)
+}
+
+// newTripPathKm - the path of the trip along its shape with its distances in straight line km between the shape
+// points, ignoring any shape_dist_traveled, whose units are those of the feed
+func newTripPathKm(trip *gtfs.Trip) *tripPath {
+	if trip.Shape == nil || len(trip.Shape.Points) < 2 {
+		path := &tripPath{}
+		for i, stopTime := range trip.StopTimes {
+			path.add(stopTime.Stop.Lat, stopTime.Stop.Lon)
+			path.stops = append(path.stops, path.points[i])
+		}
+		return path
+	}
+	return shapePath(trip, false)
+}
+
+// shapePath - the path of the trip along its shape, with the shape_dist_traveled distances if shapeDist is set
+func shapePath(trip *gtfs.Trip, shapeDist bool) *tripPath {
+	path := &tripPath{}
+	for _, point := range trip.Shape.Points {
+		path.add(point.Lat, point.Lon)
+		if shapeDist {
+			path.points[len(path.points)-1] = float64(point.Dist_traveled)
+		}
+	}
+	nearest := 0
+	for _, stopTime := range trip.StopTimes {
+		if shapeDist {
+			path.stops = append(path.stops, float64(stopTime.Shape_dist_traveled))
+			continue
+		}
+		best := math.MaxFloat64
+		for i := nearest; i < len(path.lats); i++ {
+			if d := StraightLineDistance(stopTime.Stop.Lat, stopTime.Stop.Lon, path.lats[i], path.lons[i]); d < best {
+				best, nearest = d, i
+			}
+		}
+		path.stops = append(path.stops, path.points[nearest])
+	}
+	return path
+}
+
+// add - appends a point, at the straight line distance from the previous one
+func (p *tripPath) add(lat, lon float32) {
+	total := 0.0
+	if n := len(p.lats); n > 0 {
+		total = p.points[n-1] + StraightLineDistance(p.lats[n-1], p.lons[n-1], lat, lon)
+	}
+	p.lats = append(p.lats, lat)
+	p.lons = append(p.lons, lon)
+	p.points = append(p.points, total)
+}
+
+// position - the position at the distance along the path and the bearing of the path there, in degrees clockwise from
+// north
+func (p *tripPath) position(distance float64) (lat, lon, bearing float32) {
+	i := sort.SearchFloat64s(p.points, distance)
+	switch {
+	case i == 0:
+		i = 1
+	case i >= len(p.points):
+		i = len(p.points) - 1
+	}
+	if i >= len(p.points) {
+		return p.lats[0], p.lons[0], 0
+	}
+	fraction := 0.0
+	if length := p.points[i] - p.points[i-1]; length > 0 {
+		fraction = math.Max(0, math.Min(1, (distance-p.points[i-1])/length))
+	}
+	lat = p.lats[i-1] + float32(fraction)*(p.lats[i]-p.lats[i-1])
+	lon = p.lons[i-1] + float32(fraction)*(p.lons[i]-p.lons[i-1])
+	return lat, lon, initialBearing(p.lats[i-1], p.lons[i-1], p.lats[i], p.lons[i])
+}
+
+// initialBearing - the bearing in degrees clockwise from north of the great circle from the first to the second
+// position
+func initialBearing(lat1, lon1, lat2, lon2 float32) float32 {
+	rlat1, rlat2, dLon := radians(lat1), radians(lat2), radians(lon2-lon1)
+	y := math.Sin(dLon) * math.Cos(rlat2)
+	x := math.Cos(rlat1)*math.Sin(rlat2) - math.Sin(rlat1)*math.Cos(rlat2)*math.Cos(dLon)
+	return float32(math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360))
+}
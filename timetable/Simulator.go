@@ -0,0 +1,152 @@
+package timetable
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	gtfsrt "github.com/thingful/transit_realtime"
+	"log"
+	"time"
+)
+
+// gtfsRealtimeVersion - the version of the GTFS-RT spec of the simulated feeds
+const gtfsRealtimeVersion = "2.0"
+
+// simulatedTrip - where a running trip is at an instant by its schedule
+type simulatedTrip struct {
+	*RunningTrip
+	stop    int  // index of the stop time the vehicle is at or heading to
+	stopped bool // the vehicle is dwelling at the stop
+	lat     float32
+	lon     float32
+	bearing float32
+}
+
+// SimulatedVehicleID - the simulated vehicle of a trip, named after its block so that a vehicle keeps its ID across
+// the trips of the block
+func SimulatedVehicleID(trip *gtfs.Trip) string {
+	if trip.Block_id != "" {
+		return "block-" + trip.Block_id
+	}
+	return "trip-" + trip.Id
+}
+
+// simulate - the running trips at the instant, each at the stop it is dwelling at or between the stops it is
+// travelling between, interpolated along its shape in proportion to the scheduled time
+func (s *Scheduler) simulate(at time.Time) (trips []*simulatedTrip) {
+	for _, running := range s.RunningTrips(at) {
+		trip := running.Trip
+		path := newTripPath(trip)
+		simulated := &simulatedTrip{RunningTrip: running}
+		for i, stopTime := range trip.StopTimes {
+			arrival := s.TripTime(trip, running.ServiceDate, stopTime.Arrival_time)
+			departure := s.TripTime(trip, running.ServiceDate, stopTime.Departure_time)
+			simulated.stop = i
+			if at.Before(arrival) && i > 0 {
+				previous := s.TripTime(trip, running.ServiceDate, trip.StopTimes[i-1].Departure_time)
+				fraction := 1.0
+				if duration := arrival.Sub(previous); duration > 0 {
+					fraction = float64(at.Sub(previous)) / float64(duration)
+				}
+				distance := path.stops[i-1] + fraction*(path.stops[i]-path.stops[i-1])
+				simulated.lat, simulated.lon, simulated.bearing = path.position(distance)
+				break
+			}
+			if !at.After(departure) || i == len(trip.StopTimes)-1 {
+				simulated.stopped = true
+				simulated.lat, simulated.lon, simulated.bearing = path.position(path.stops[i])
+				break
+			}
+		}
+		trips = append(trips, simulated)
+	}
+	return trips
+}
+
+// vehicleID - the simulated vehicle of the running trip. The trips generated from a frequency may run at the same
+// time, so each has a vehicle of its own rather than that of the block
+func (trip *simulatedTrip) vehicleID() string {
+	if trip.Frequency != nil {
+		return "trip-" + trip.Trip.Id
+	}
+	return SimulatedVehicleID(trip.Trip)
+}
+
+// feedMessage - an empty full dataset GTFS-RT feed at the instant
+func feedMessage(at time.Time) *gtfsrt.FeedMessage {
+	return &gtfsrt.FeedMessage{
+		Header: &gtfsrt.FeedHeader{
+			GtfsRealtimeVersion: proto.String(gtfsRealtimeVersion),
+			Incrementality:      gtfsrt.FeedHeader_FULL_DATASET.Enum(),
+			Timestamp:           proto.Uint64(uint64(at.Unix())),
+		},
+	}
+}
+
+// tripDescriptor - the GTFS-RT descriptor of the running trip. A trip generated from frequencies.txt is described by
+// the trip_id of its template and its start_time
+func (trip *simulatedTrip) tripDescriptor() *gtfsrt.TripDescriptor {
+	tripID := trip.Trip.Id
+	if trip.Frequency != nil {
+		tripID = trip.Frequency.Template.Id
+	}
+	return &gtfsrt.TripDescriptor{
+		TripId:               proto.String(tripID),
+		RouteId:              proto.String(trip.Trip.Route.Id),
+		StartTime:            proto.String(GTFSTime(trip.Trip.StopTimes[0].Departure_time)),
+		StartDate:            proto.String(fmt.Sprintf("%04d%02d%02d", trip.ServiceDate.Year, trip.ServiceDate.Month, trip.ServiceDate.Day)),
+		ScheduleRelationship: gtfsrt.TripDescriptor_SCHEDULED.Enum(),
+	}
+}
+
+// SimulateTripUpdates - a TripUpdates feed of the trips running at the instant, every trip running late by the
+// delay, with the predicted times of the stops it has still to leave
+func (s *Scheduler) SimulateTripUpdates(at time.Time, delay time.Duration) *gtfsrt.FeedMessage {
+	message := feedMessage(at)
+	for _, trip := range s.simulate(at.Add(-delay)) {
+		update := &gtfsrt.TripUpdate{
+			Trip:      trip.tripDescriptor(),
+			Vehicle:   &gtfsrt.VehicleDescriptor{Id: proto.String(trip.vehicleID())},
+			Timestamp: proto.Uint64(uint64(at.Unix())),
+		}
+		for _, stopTime := range trip.Trip.StopTimes[trip.stop:] {
+			arrival := s.TripTime(trip.Trip, trip.ServiceDate, stopTime.Arrival_time).Add(delay)
+			departure := s.TripTime(trip.Trip, trip.ServiceDate, stopTime.Departure_time).Add(delay)
+			update.StopTimeUpdate = append(update.StopTimeUpdate, &gtfsrt.TripUpdate_StopTimeUpdate{
+				StopSequence: proto.Uint32(uint32(stopTime.Sequence)),
+				StopId:       proto.String(stopTime.Stop.Id),
+				Arrival:      &gtfsrt.TripUpdate_StopTimeEvent{Delay: proto.Int32(int32(delay / time.Second)), Time: proto.Int64(arrival.Unix())},
+				Departure:    &gtfsrt.TripUpdate_StopTimeEvent{Delay: proto.Int32(int32(delay / time.Second)), Time: proto.Int64(departure.Unix())},
+			})
+		}
+		message.Entity = append(message.Entity, &gtfsrt.FeedEntity{Id: proto.String(trip.Trip.Id), TripUpdate: update})
+	}
+	log.Printf("Simulated TripUpdates at %s: %d trips\n", at.In(s.Location()).Format(time.RFC3339), len(message.Entity))
+	return message
+}
+
+// SimulateVehiclePositions - a VehiclePositions feed of the trips running at the instant, every trip running late by
+// the delay, with each vehicle at its interpolated position
+func (s *Scheduler) SimulateVehiclePositions(at time.Time, delay time.Duration) *gtfsrt.FeedMessage {
+	message := feedMessage(at)
+	for _, trip := range s.simulate(at.Add(-delay)) {
+		stopTime := trip.Trip.StopTimes[trip.stop]
+		status := gtfsrt.VehiclePosition_IN_TRANSIT_TO
+		if trip.stopped {
+			status = gtfsrt.VehiclePosition_STOPPED_AT
+		}
+		vehicleID := trip.vehicleID()
+		position := &gtfsrt.VehiclePosition{
+			Trip:                trip.tripDescriptor(),
+			Vehicle:             &gtfsrt.VehicleDescriptor{Id: proto.String(vehicleID), Label: proto.String(vehicleID)},
+			Position:            &gtfsrt.Position{Latitude: proto.Float32(trip.lat), Longitude: proto.Float32(trip.lon), Bearing: proto.Float32(trip.bearing)},
+			CurrentStopSequence: proto.Uint32(uint32(stopTime.Sequence)),
+			StopId:              proto.String(stopTime.Stop.Id),
+			CurrentStatus:       status.Enum(),
+			Timestamp:           proto.Uint64(uint64(at.Unix())),
+		}
+		message.Entity = append(message.Entity, &gtfsrt.FeedEntity{Id: proto.String(trip.Trip.Id), Vehicle: position})
+	}
+	log.Printf("Simulated VehiclePositions at %s: %d vehicles\n", at.In(s.Location()).Format(time.RFC3339), len(message.Entity))
+	return message
+}
@@ -0,0 +1,542 @@
+package timetable
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser"      //"github.com/geops/gtfsparser"
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StopSchedule -
+type StopSchedule struct {
+	Route     *gtfs.Route
+	StopTimes []*StopTime
+}
+
+// StopTime -
+type StopTime struct {
+	Route         *gtfs.Route
+	Trip          *gtfs.Trip
+	Service       *gtfs.Service
+	Stop          *gtfs.Stop // stop or platform at which the trip calls
+	ArrivalTime   gtfs.Time
+	DepartureTime gtfs.Time
+	Headsign      string // stop_headsign of the stop time, or else the trip_headsign
+	PickupType    int8
+	DropOffType   int8
+	ServiceDate   gtfs.Date // service day of the trip
+	Day           int       // days from the service day to the day the stop time is shown under
+	Headway       int       // seconds between the approximate departures of a frequency band, zero for a single departure
+	Until         gtfs.Time // arrival of the last departure of the frequency band
+}
+
+// arrivalSeconds - seconds from the start of the day the stop time is shown under
+func (st *StopTime) arrivalSeconds() int {
+	return toSeconds(st.ArrivalTime) - st.Day*secondsPerDay
+}
+
+func stringtoInt(input string) (result int) {
+	result, err := strconv.Atoi(input)
+	if err != nil {
+		result = 0
+	}
+	return result
+}
+
+// Schedules -
+type Schedules []*StopSchedule
+
+// Len -
+func (s Schedules) Len() int { return len(s) }
+
+// Swap -
+func (s Schedules) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// ByRouteID -
+type ByRouteID struct{ Schedules }
+
+// Less -
+func (s ByRouteID) Less(i, j int) bool {
+	return stringtoInt(s.Schedules[i].Route.Id) < stringtoInt(s.Schedules[j].Route.Id)
+}
+
+// StopTimes -
+type StopTimes []*StopTime
+
+// Len -
+func (s StopTimes) Len() int { return len(s) }
+
+// Swap -
+func (s StopTimes) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// ByArrivalTime -
+type ByArrivalTime struct{ StopTimes }
+
+// Less -
+func (s ByArrivalTime) Less(i, j int) bool {
+	return s.StopTimes[i].arrivalSeconds() < s.StopTimes[j].arrivalSeconds()
+}
+
+// Timetable -
+type Timetable struct {
+	StopTimes [7][]*StopTime
+}
+
+// dayOffset - the number of days from its service day to the day a GTFS time is shown under
+func (s *Scheduler) dayOffset(t gtfs.Time) int {
+	if s.NextDayDisplay {
+		return CalendarDayOffset(t)
+	}
+	return s.ServiceDayOffset(t)
+}
+
+// stopTimesOn - returns the times at which trips call at the stop on the specified date, including any calendar
+// exceptions on that exact date. Times past 24:00 normally stay on their service day, while times before
+// StartOfDay belong to the previous operating day
+func (s *Scheduler) stopTimesOn(stops map[string]*gtfs.Stop, date gtfs.Date) (stopTimes []*StopTime) {
+	for days := -1; days <= 1; days++ {
+		serviceDate := DateAdd(date, days)
+		for _, v3 := range s.ServicesOn(serviceDate) {
+			v := s.servicetable(v3.Id)
+			if v == nil {
+				continue
+			}
+			for _, v1 := range v.Trips {
+				for _, v2 := range v1.StopTimes {
+					if stops[v2.Stop.Id] != nil && s.dayOffset(v2.Arrival_time) == -days {
+						stopTime := StopTime{}
+						stopTime.Route = v1.Route
+						stopTime.Trip = v1
+						stopTime.Service = v.Service
+						stopTime.Stop = v2.Stop
+						stopTime.ArrivalTime = v2.Arrival_time
+						stopTime.DepartureTime = v2.Departure_time
+						stopTime.Headsign = v2.Headsign
+						if stopTime.Headsign == "" {
+							stopTime.Headsign = v1.Headsign
+						}
+						stopTime.PickupType = v2.Pickup_type
+						stopTime.DropOffType = v2.Drop_off_type
+						stopTime.ServiceDate = serviceDate
+						stopTime.Day = -days
+						stopTimes = append(stopTimes, &stopTime)
+					}
+				}
+			}
+		}
+	}
+	return stopTimes
+}
+
+// stopSet - indexes the stops by stop ID
+func stopSet(stops []*gtfs.Stop) map[string]*gtfs.Stop {
+	set := make(map[string]*gtfs.Stop)
+	for _, stop := range stops {
+		set[stop.Id] = stop
+	}
+	return set
+}
+
+// CreateTimetable - creates the weekly timetable of the specified stops, merging the stop times of every stop or
+// platform into a single Timetable
+func (s *Scheduler) CreateTimetable(stops []*gtfs.Stop, weekEnding gtfs.Date) (timetable Timetable) {
+	timetable = Timetable{}
+	// The timetables are generated for the specified stops on a weekly basis
+	stopIDs := stopSet(stops)
+	for i := 0; i < len(timetable.StopTimes); i++ {
+		log.Printf("\nWeekday[%d]:", i)
+		timetable.StopTimes[i] = s.stopTimesOn(stopIDs, weekDate(weekEnding, i))
+	}
+	return timetable
+}
+
+// StopDay -
+type StopDay struct {
+	Date      gtfs.Date
+	StopTimes []*StopTime
+}
+
+// StopCalendar -
+type StopCalendar struct {
+	StopDays []*StopDay
+}
+
+// CreateStopCalendar - creates the timetable of the specified stops for each date from start to end inclusive
+func (s *Scheduler) CreateStopCalendar(stops []*gtfs.Stop, start, end gtfs.Date) (stopCalendar StopCalendar) {
+	stopCalendar = StopCalendar{}
+	stopIDs := stopSet(stops)
+	for date := start; !DateBefore(end, date); date = DateAdd(date, 1) {
+		log.Printf("\nDate[%s]:", Datestamp(date))
+		stopDay := StopDay{}
+		stopDay.Date = date
+		stopDay.StopTimes = s.stopTimesOn(stopIDs, date)
+		stopCalendar.StopDays = append(stopCalendar.StopDays, &stopDay)
+	}
+	return stopCalendar
+}
+
+// TimeType -
+type TimeType int
+
+const (
+	hhmmss TimeType = iota
+	hhmm
+	mm
+)
+
+// Timestamp - formats the clock time of a GTFS time, so 25:10 is shown as 01:10
+func Timestamp(a gtfs.Time, t TimeType) (timestamp string) {
+	hour := int(a.Hour) - 24*daysOffset(toSeconds(a))
+	if t == hhmmss {
+		timestamp = fmt.Sprintf("%02d:%02d:%02d", hour, a.Minute, a.Second)
+	} else if t == hhmm {
+		timestamp = fmt.Sprintf("%02d:%02d", hour, a.Minute)
+	} else if t == mm {
+		timestamp = fmt.Sprintf(":%02d", a.Minute)
+	}
+	return
+}
+
+// TextStyle -
+type TextStyle struct {
+	textFrame string
+	textFont  string
+	textColor string
+	color     string
+}
+
+func firstWords(value string, count int) string {
+	// Loop over all indexes in the string.
+	for i := range value {
+		// If we encounter a space, reduce the count.
+		if value[i] == ' ' {
+			count--
+			// When no more words required, return a substring.
+			if count == 0 {
+				return value[0:i]
+			}
+		}
+	}
+	// Return the entire string.
+	return value
+}
+
+// TimeItem -
+func TimeItem(feed *gtfsparser.Feed, item []*StopTime, max, index int) (routeName string, scheduleTime string) {
+	var timeType TimeType
+	if index > 0 && index < max && item[index-1].arrivalSeconds()/3600 == item[index].arrivalSeconds()/3600 && !item[index-1].isBand() {
+		timeType = mm
+	} else {
+		timeType = hhmm
+	}
+
+	if index < max {
+		routeName = item[index].Route.Short_name
+		if len(routeName) == 0 {
+			routeName = item[index].Trip.Short_name
+		}
+		if len(routeName) == 0 {
+			routeName = firstWords(item[index].Trip.Headsign, 1)
+		}
+		scheduleTime = Timestamp(item[index].ArrivalTime, timeType)
+		if item[index].isBand() {
+			scheduleTime = FrequencyText(item[index])
+		}
+	} else {
+		routeName = ""
+		scheduleTime = ""
+	}
+	return routeName, scheduleTime
+}
+
+// Platform - the platform_code of the stop, or else its stop_code
+func Platform(stop *gtfs.Stop) string {
+	if stop == nil {
+		return ""
+	}
+	if stop.Platform_code != "" {
+		return stop.Platform_code
+	}
+	return stop.Code
+}
+
+// PlatformItem - the platform of the stop time at the index, or empty beyond the end of the list
+func PlatformItem(item []*StopTime, max, index int) string {
+	if index < max {
+		return Platform(item[index].Stop)
+	}
+	return ""
+}
+
+// TimeItemCSV -
+func TimeItemCSV(feed *gtfsparser.Feed, item []*StopTime, max, index int) (text string) {
+	routeID, scheduleTime := TimeItem(feed, item, max, index)
+	text = routeID + "," + PlatformItem(item, max, index) + "," + scheduleTime + ","
+	return text
+}
+
+// StopsTitle - the stop codes & description of the timetable stops, or the parent station they share
+func StopsTitle(stops []*gtfs.Stop) string {
+	if len(stops) == 0 {
+		return ""
+	}
+	station := stops[0].Parent_station
+	var codes []string
+	for _, stop := range stops {
+		if stop.Parent_station != station {
+			station = nil
+		}
+		codes = append(codes, stop.Code)
+	}
+	if len(stops) > 1 && station != nil {
+		return "Station #" + station.Id + " - " + station.Name
+	}
+	return "Stop #" + strings.Join(codes, "/") + " - " + stops[0].Desc
+}
+
+// SortTimetable -
+func SortTimetable(timetable Timetable) {
+	for i := 0; i < len(timetable.StopTimes); i++ {
+		sort.Sort(ByArrivalTime{timetable.StopTimes[i]})
+	}
+}
+
+// SortStopCalendar -
+func SortStopCalendar(stopCalendar StopCalendar) {
+	for _, day := range stopCalendar.StopDays {
+		sort.Sort(ByArrivalTime{day.StopTimes})
+	}
+}
+
+// DayOfWeek -
+type DayOfWeek struct {
+	name   string
+	abbrev string
+}
+
+// DaysOfWeek -
+type DaysOfWeek struct {
+	lang       string
+	daysOfWeek [7]DayOfWeek
+}
+
+var daysOfWeekPt = [7]DayOfWeek{
+	DayOfWeek{"Dominga", "DOM"},
+	DayOfWeek{"Segunda-feira", "SEG"},
+	DayOfWeek{"Terça-feira", "TER"},
+	DayOfWeek{"Quarta-feira", "QUA"},
+	DayOfWeek{"Quinta-feira", "QUI"},
+	DayOfWeek{"Sexta-feira", "SEX"},
+	DayOfWeek{"Sábado", "SÁB"},
+}
+
+var daysOfWeekEs = [7]DayOfWeek{
+	DayOfWeek{"Dominga", "DOM"},
+	DayOfWeek{"Lunes", "LUN"},
+	DayOfWeek{"Martes", "MAR"},
+	DayOfWeek{"Miércoles", "MIÉ"},
+	DayOfWeek{"Jueves", "JEU"},
+	DayOfWeek{"Viernes", "VIE"},
+	DayOfWeek{"Sábado", "SÁB"},
+}
+
+var daysOfWeekFr = [7]DayOfWeek{
+	DayOfWeek{"Dimanche", "DIM"},
+	DayOfWeek{"Lundi", "LUN"},
+	DayOfWeek{"Mardi", "MAR"},
+	DayOfWeek{"Mercredi", "MER"},
+	DayOfWeek{"Jeudi", "JEU"},
+	DayOfWeek{"Vendredi", "VEN"},
+	DayOfWeek{"Samedi", "SAM"},
+}
+
+var daysOfWeekEn = [7]DayOfWeek{
+	DayOfWeek{"Sunday", "SUN"},
+	DayOfWeek{"Monday", "MON"},
+	DayOfWeek{"Tuesday", "TUE"},
+	DayOfWeek{"Wednesday", "WED"},
+	DayOfWeek{"Thursday", "THU"},
+	DayOfWeek{"Friday", "FRI"},
+	DayOfWeek{"Saturday", "SAT"},
+}
+
+var daysOfWeekDef = [7]DayOfWeek{
+	DayOfWeek{"1", "1"},
+	DayOfWeek{"2", "2"},
+	DayOfWeek{"3", "3"},
+	DayOfWeek{"4", "4"},
+	DayOfWeek{"5", "5"},
+	DayOfWeek{"6", "6"},
+	DayOfWeek{"7", "7"},
+}
+
+var daysOfWeekList = []DaysOfWeek{
+	{"en", daysOfWeekEn},
+	{"es", daysOfWeekEs},
+	{"pt", daysOfWeekPt},
+	{"fr", daysOfWeekFr},
+	{"def", daysOfWeekDef},
+}
+
+// Languages - the languages of the weekday names
+func Languages() (langs []string) {
+	for _, v := range daysOfWeekList {
+		if v.lang != "def" {
+			langs = append(langs, v.lang)
+		}
+	}
+	return langs
+}
+
+// daysOfWeekAbbrev - returns the weekday abbreviations in the Scheduler language, or else the agency language
+func (s *Scheduler) daysOfWeekAbbrev(agency *gtfs.Agency) (days [7]string) {
+	lang := s.Lang
+	if lang == "" {
+		lang = agency.Lang.GetLangString()
+	}
+	daysOfWeek := daysOfWeekDef
+	for _, v1 := range daysOfWeekList {
+		if v1.lang == lang {
+			daysOfWeek = v1.daysOfWeek
+			break
+		}
+	}
+	for k2, v2 := range daysOfWeek {
+		days[k2] = v2.abbrev
+	}
+	return days
+}
+
+// PrintTimetableCSV -
+func (s *Scheduler) PrintTimetableCSV(filename string, timetable Timetable, stops []*gtfs.Stop, weekEnding gtfs.Date) (err error) {
+	timetable = s.timetableBands(timetable)
+	feed := s.Feed
+	for _, feedInfo := range feed.FeedInfos {
+		title := feedInfo.Publisher_name + "\n" + "Version:" + feedInfo.Version + "\n"
+		for _, agency := range s.agencies() {
+			d := s.daysOfWeekAbbrev(agency)
+			file, err := s.createFile(agency, filename)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			tableLength := 0
+			for i := 0; i < len(timetable.StopTimes); i++ {
+				if len(timetable.StopTimes[i]) > tableLength {
+					tableLength = len(timetable.StopTimes[i])
+				}
+			}
+			start, end := getFeedDateRange(feed, 0)
+			header := fmt.Sprintf("%s\nTransit Schedule\n%s\nFrom: %s - To: %s\n%s\n#,P,%s,#,P,%s,#,P,%s,#,P,%s,#,P,%s,#,P,%s,#,P,%s\n", agency.Name, StopsTitle(stops), Datestamp(start), Datestamp(end), WeekDateCSV(timetable, weekEnding), d[time.Monday], d[time.Tuesday], d[time.Wednesday], d[time.Thursday], d[time.Friday], d[time.Saturday], d[time.Sunday])
+			file.WriteString(title + header)
+			log.Printf(title + header)
+
+			for index := 0; index < tableLength; index++ {
+				var line string
+				for weekday := time.Monday; weekday <= time.Saturday; weekday++ {
+					line += TimeItemCSV(feed, timetable.StopTimes[weekday], len(timetable.StopTimes[weekday]), index)
+				}
+				line += TimeItemCSV(feed, timetable.StopTimes[time.Sunday], len(timetable.StopTimes[time.Sunday]), index)
+				line += "\n"
+				_, err = file.WriteString(line)
+				log.Printf("%s", line)
+				if err != nil {
+					return err
+				}
+				file.Sync()
+			}
+		}
+	}
+	return err
+}
+
+// PrintStopCalendarCSV - prints a stop timetable covering an arbitrary date range, one group of columns per date
+func (s *Scheduler) PrintStopCalendarCSV(filename string, stopCalendar StopCalendar, stops []*gtfs.Stop) (err error) {
+	stopCalendar = s.stopCalendarBands(stopCalendar)
+	feed := s.Feed
+	for _, feedInfo := range feed.FeedInfos {
+		title := feedInfo.Publisher_name + "\n" + "Version:" + feedInfo.Version + "\n"
+		for _, agency := range s.agencies() {
+			d := s.daysOfWeekAbbrev(agency)
+			file, err := s.createFile(agency, filename)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			tableLength := 0
+			ribbon := ""
+			days := ""
+			for _, stopDay := range stopCalendar.StopDays {
+				if len(stopDay.StopTimes) > tableLength {
+					tableLength = len(stopDay.StopTimes)
+				}
+				serviceID := ""
+				if len(stopDay.StopTimes) != 0 {
+					serviceID = stopDay.StopTimes[0].Service.Id
+				}
+				ribbon += serviceID + ",," + strconv.Itoa(int(stopDay.Date.Day)) + ","
+				days += "#,P," + d[dayOfWeek(stopDay.Date)] + ","
+			}
+			period := ""
+			if len(stopCalendar.StopDays) != 0 {
+				period = Period(stopCalendar.StopDays[0].Date, stopCalendar.StopDays[len(stopCalendar.StopDays)-1].Date)
+			}
+			start, end := getFeedDateRange(feed, 0)
+			header := fmt.Sprintf("%s\nTransit Schedule\n%s\nFrom: %s - To: %s\n%s\n%s\n%s\n", agency.Name, StopsTitle(stops), Datestamp(start), Datestamp(end), period, ribbon, days)
+			file.WriteString(title + header)
+			log.Printf(title + header)
+
+			for index := 0; index < tableLength; index++ {
+				var line string
+				for _, stopDay := range stopCalendar.StopDays {
+					line += TimeItemCSV(feed, stopDay.StopTimes, len(stopDay.StopTimes), index)
+				}
+				line += "\n"
+				_, err = file.WriteString(line)
+				log.Printf("%s", line)
+				if err != nil {
+					return err
+				}
+				file.Sync()
+			}
+		}
+	}
+	return err
+}
+
+func getFeedDateRange(feed *gtfsparser.Feed, index int) (start, end gtfs.Date) {
+	start = feed.FeedInfos[index].Start_date
+	end = feed.FeedInfos[index].End_date
+	return start, end
+}
+
+// WeekDateRibbonCSV - the service ID & date of each weekday column, left blank for days without stop times
+func WeekDateRibbonCSV(timetable Timetable, weekEnding gtfs.Date) (csv string) {
+	days := WeekDates(weekEnding)
+	for i := time.Monday; i <= time.Saturday; i++ {
+		csv += (weekdayServiceID(timetable.StopTimes[i]) + ",," + days[i-1] + ",")
+	}
+	csv += (weekdayServiceID(timetable.StopTimes[time.Sunday]) + ",," + days[6] + ",")
+	return csv
+}
+
+func weekdayServiceID(stopTimes []*StopTime) string {
+	if len(stopTimes) == 0 {
+		return ""
+	}
+	return stopTimes[0].Service.Id
+}
+
+// WeekDateCSV -
+func WeekDateCSV(timetable Timetable, weekEnding gtfs.Date) (text string) {
+	log.Printf("\nWeekDateCSV():")
+	text = WeekEnding(weekEnding) + "\n" + WeekDateRibbonCSV(timetable, weekEnding)
+	log.Printf("%s - end", text)
+	return text
+}
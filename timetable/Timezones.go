@@ -0,0 +1,70 @@
+package timetable
+
+import (
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"log"
+	"time"
+)
+
+// loadLocation - loads the time zone of a GTFS agency_timezone or stop_timezone, or nil if it is empty or unknown
+func loadLocation(timezone gtfs.Timezone) *time.Location {
+	tz := timezone.GetTzString()
+	if tz == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		log.Println("Time zone:", tz, err)
+		return nil
+	}
+	return loc
+}
+
+// loadLocations - loads the agency & stop time zones of the feed
+func (s *Scheduler) loadLocations() {
+	s.locations = make(map[string]*time.Location)
+	for _, agency := range s.Feed.Agencies {
+		if loc := loadLocation(agency.Timezone); loc != nil {
+			s.locations["agency:"+agency.Id] = loc
+		}
+	}
+	for _, stop := range s.Feed.Stops {
+		if loc := loadLocation(stop.Timezone); loc != nil {
+			s.locations["stop:"+stop.Id] = loc
+		}
+	}
+}
+
+// AgencyLocation - the agency_timezone in which the GTFS times of the agency trips are given, or else the local time zone
+func (s *Scheduler) AgencyLocation(agency *gtfs.Agency) *time.Location {
+	if agency != nil {
+		if loc := s.locations["agency:"+agency.Id]; loc != nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// StopLocation - the stop_timezone of the stop, or of its parent station, or else the agency time zone
+func (s *Scheduler) StopLocation(stop *gtfs.Stop, agency *gtfs.Agency) *time.Location {
+	for ; stop != nil; stop = stop.Parent_station {
+		if loc := s.locations["stop:"+stop.Id]; loc != nil {
+			return loc
+		}
+	}
+	return s.AgencyLocation(agency)
+}
+
+// Location - the time zone of the agency selected for output, or of the first agency of the feed
+func (s *Scheduler) Location() *time.Location {
+	agencies := s.agencies()
+	if len(agencies) == 0 {
+		return time.Local
+	}
+	return s.AgencyLocation(agencies[0])
+}
+
+// TripTime - the instant of a GTFS time of the trip on the service date, in the time zone of the trip agency
+func (s *Scheduler) TripTime(trip *gtfs.Trip, date gtfs.Date, t gtfs.Time) time.Time {
+	return ServiceTime(date, t, s.AgencyLocation(trip.Route.Agency))
+}
@@ -0,0 +1,357 @@
+package timetable
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/patrickbr/gtfsparser/gtfs" //"github.com/geops/gtfsparser/gtfs"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severity of a validation problem
+const (
+	SeverityError   = "error"   // the reports built from the feed will be wrong or ambiguous
+	SeverityWarning = "warning" // the feed is usable but probably not what was intended
+)
+
+// Problem - a problem found by Validate, with the GTFS file & ID of the item it concerns
+type Problem struct {
+	Severity string
+	Category string // referential, calendar or block
+	Check    string
+	File     string
+	ID       string
+	Message  string
+}
+
+// Validation - the problems found in a feed
+type Validation struct {
+	Feed     string
+	Errors   int
+	Warnings int
+	Problems []*Problem
+}
+
+func (v *Validation) add(severity, category, check, file, id, format string, a ...interface{}) {
+	v.Problems = append(v.Problems, &Problem{severity, category, check, file, id, fmt.Sprintf(format, a...)})
+	if severity == SeverityError {
+		v.Errors++
+	} else {
+		v.Warnings++
+	}
+}
+
+// Validate - checks the references between the trips, stops & services, the service calendars and the blocks of the
+// feed. The problems are ordered by category, check and ID
+func (s *Scheduler) Validate(name string) *Validation {
+	v := &Validation{Feed: name, Problems: []*Problem{}}
+	s.validateTrips(v)
+	s.validateStops(v)
+	s.validateServices(v)
+	s.validateBlocks(v)
+	order := map[string]int{"referential": 0, "calendar": 1, "block": 2}
+	sort.SliceStable(v.Problems, func(i, j int) bool {
+		a, b := v.Problems[i], v.Problems[j]
+		if a.Category != b.Category {
+			return order[a.Category] < order[b.Category]
+		}
+		if a.Check != b.Check {
+			return a.Check < b.Check
+		}
+		return a.ID < b.ID
+	})
+	log.Printf("Validation %s: %d errors, %d warnings\n", name, v.Errors, v.Warnings)
+	return v
+}
+
+// stopSequenceRow - the first row of a trip in stop_times.txt whose stop_sequence is not above that of the trip's
+// previous row
+type stopSequenceRow struct {
+	Row      int // line of stop_times.txt, the header being line 1
+	Sequence int
+	Previous int
+}
+
+// readStopSequenceOrder - reads stop_times.txt of the GTFS zip file (or folder) in file order, which the parser does
+// not keep as it sorts the stop times of each trip by stop_sequence, and returns the first row out of order of each
+// trip
+func readStopSequenceOrder(zipFile string) (rows map[string]stopSequenceRow, err error) {
+	var file io.ReadCloser
+	if info, err := os.Stat(zipFile); err != nil {
+		return nil, err
+	} else if info.IsDir() {
+		if file, err = os.Open(filepath.Join(zipFile, "stop_times.txt")); err != nil {
+			return nil, err
+		}
+	} else {
+		archive, err := zip.OpenReader(zipFile)
+		if err != nil {
+			return nil, err
+		}
+		defer archive.Close()
+		for _, entry := range archive.File {
+			if filepath.Base(entry.Name) == "stop_times.txt" {
+				if file, err = entry.Open(); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+		if file == nil {
+			return nil, fmt.Errorf("%s has no stop_times.txt", zipFile)
+		}
+	}
+	defer file.Close()
+	return stopSequenceOrder(file)
+}
+
+// stopSequenceOrder - the first row out of stop_sequence order of each trip of the stop_times.txt CSV
+func stopSequenceOrder(file io.Reader) (map[string]stopSequenceRow, error) {
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	tripColumn, sequenceColumn := -1, -1
+	for i, name := range header {
+		switch strings.TrimSpace(strings.TrimPrefix(name, "\uFEFF")) {
+		case "trip_id":
+			tripColumn = i
+		case "stop_sequence":
+			sequenceColumn = i
+		}
+	}
+	if tripColumn < 0 || sequenceColumn < 0 {
+		return nil, fmt.Errorf("stop_times.txt has no trip_id or stop_sequence column")
+	}
+	rows := make(map[string]stopSequenceRow)
+	previous := make(map[string]int)
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) <= tripColumn || len(record) <= sequenceColumn {
+			continue
+		}
+		tripID := strings.TrimSpace(record[tripColumn])
+		sequence, err := strconv.Atoi(strings.TrimSpace(record[sequenceColumn]))
+		if err != nil {
+			continue
+		}
+		if last, ok := previous[tripID]; ok && sequence <= last {
+			if _, reported := rows[tripID]; !reported {
+				rows[tripID] = stopSequenceRow{row, sequence, last}
+			}
+		}
+		previous[tripID] = sequence
+	}
+}
+
+// validateTrips - trips without a service or stop times, and stop times out of stop_sequence order, either as rows of
+// stop_times.txt or by their times
+func (s *Scheduler) validateTrips(v *Validation) {
+	for _, trip := range s.Feed.Trips {
+		if row, ok := s.stopSequenceRows[trip.Id]; ok {
+			v.add(SeverityWarning, "calendar", "stop_sequence_order", "stop_times.txt", trip.Id,
+				"row %d has stop_sequence %d after stop_sequence %d", row.Row, row.Sequence, row.Previous)
+		}
+		if trip.Service == nil {
+			v.add(SeverityError, "referential", "trip_without_service", "trips.txt", trip.Id, "trip has no service_id in calendar.txt or calendar_dates.txt")
+		}
+		if len(trip.StopTimes) == 0 {
+			v.add(SeverityWarning, "referential", "trip_without_stop_times", "trips.txt", trip.Id, "trip has no stop times")
+		}
+		for i := 1; i < len(trip.StopTimes); i++ {
+			previous, stopTime := trip.StopTimes[i-1], trip.StopTimes[i]
+			switch {
+			case previous.Sequence == stopTime.Sequence:
+				v.add(SeverityError, "calendar", "stop_sequence_order", "stop_times.txt", trip.Id,
+					"stop_sequence %d is used twice, by stops %s and %s", stopTime.Sequence, previous.Stop.Id, stopTime.Stop.Id)
+			case toSeconds(stopTime.Arrival_time) < toSeconds(previous.Departure_time):
+				v.add(SeverityError, "calendar", "stop_sequence_order", "stop_times.txt", trip.Id,
+					"stop_sequence %d arrives at %s before stop_sequence %d departs at %s", stopTime.Sequence,
+					GTFSTime(stopTime.Arrival_time), previous.Sequence, GTFSTime(previous.Departure_time))
+			}
+		}
+	}
+}
+
+// validateStops - stops & platforms without a stop_code, and stop_codes shared by several stops, which make the stop
+// lookups by code ambiguous
+func (s *Scheduler) validateStops(v *Validation) {
+	codes := make(map[string][]string)
+	for _, stop := range s.Feed.Stops {
+		if stop.Code == "" {
+			if stop.Location_type == 0 {
+				v.add(SeverityWarning, "referential", "stop_without_code", "stops.txt", stop.Id, "stop %q has no stop_code", stop.Name)
+			}
+			continue
+		}
+		codes[stop.Code] = append(codes[stop.Code], stop.Id)
+	}
+	for code, stopIDs := range codes {
+		if len(stopIDs) > 1 {
+			sort.Strings(stopIDs)
+			v.add(SeverityError, "referential", "duplicate_stop_code", "stops.txt", code, "stop_code is shared by stops %s", strings.Join(stopIDs, ", "))
+		}
+	}
+}
+
+// validateServices - services that never operate, and services outside the feed_info.txt date range
+func (s *Scheduler) validateServices(v *Validation) {
+	for _, service := range s.Feed.Services {
+		if emptyDaymap(service) && len(service.Exceptions) == 0 {
+			v.add(SeverityError, "calendar", "service_never_operates", "calendar.txt", service.Id, "service has no weekdays and no calendar_dates.txt exceptions")
+			continue
+		}
+		first, last := service.GetFirstDefinedDate(), service.GetLastDefinedDate()
+		for _, feedInfo := range s.Feed.FeedInfos {
+			if feedInfo.Start_date.Year > 0 && DateBefore(first, feedInfo.Start_date) {
+				v.add(SeverityWarning, "calendar", "feed_info_range", "feed_info.txt", service.Id,
+					"service starts on %s, before feed_start_date %s", Datestamp(first), Datestamp(feedInfo.Start_date))
+			}
+			if feedInfo.End_date.Year > 0 && DateBefore(feedInfo.End_date, last) {
+				v.add(SeverityWarning, "calendar", "feed_info_range", "feed_info.txt", service.Id,
+					"service ends on %s, after feed_end_date %s", Datestamp(last), Datestamp(feedInfo.End_date))
+			}
+		}
+	}
+}
+
+// validateBlocks - trips of a block that overlap in time on a day both operate, counting trips past 24:00 on the
+// following calendar day
+func (s *Scheduler) validateBlocks(v *Validation) {
+	dates := make(map[*gtfs.Service]map[gtfs.Date]bool)
+	serviceDates := func(service *gtfs.Service) map[gtfs.Date]bool {
+		if dates[service] == nil {
+			dates[service] = make(map[gtfs.Date]bool)
+			first, last := service.GetFirstDefinedDate(), service.GetLastDefinedDate()
+			for date := first; !DateBefore(last, date); date = DateAdd(date, 1) {
+				if ServiceActiveOn(service, date) {
+					dates[service][date] = true
+				}
+			}
+		}
+		return dates[service]
+	}
+	for _, blocktable := range s.Blocktables {
+		if blocktable.BlockID == "" {
+			continue
+		}
+		var trips []*gtfs.Trip
+		for _, servicetable := range blocktable.Servicetables {
+			trips = append(trips, servicetable.Trips...)
+		}
+		sort.Slice(trips, func(i, j int) bool { return trips[i].Id < trips[j].Id })
+		for i, a := range trips {
+			for _, b := range trips[i+1:] {
+				if a.Service == nil || b.Service == nil || len(a.StopTimes) == 0 || len(b.StopTimes) == 0 {
+					continue
+				}
+				// Each trip generated from frequencies.txt has a vehicle of its own, so it may overlap the others
+				if s.FrequencyOf(a) != nil || s.FrequencyOf(b) != nil {
+					continue
+				}
+				for days := -1; days <= 1; days++ {
+					offset := days * secondsPerDay
+					if startSeconds(a) >= endSeconds(b)+offset || startSeconds(b)+offset >= endSeconds(a) {
+						continue
+					}
+					var shared []gtfs.Date
+					datesB := serviceDates(b.Service)
+					for date := range serviceDates(a.Service) {
+						if datesB[DateAdd(date, days)] {
+							shared = append(shared, date)
+						}
+					}
+					if len(shared) == 0 {
+						continue
+					}
+					sort.Slice(shared, func(i, j int) bool { return DateBefore(shared[i], shared[j]) })
+					next := map[int]string{-1: " of the previous day", 0: "", 1: " of the next day"}[days]
+					v.add(SeverityError, "block", "block_trips_overlap", "trips.txt", blocktable.BlockID,
+						"trips %s %s-%s and %s %s-%s%s overlap on %d days from %s", a.Id, tripSpan(a)[0], tripSpan(a)[1],
+						b.Id, tripSpan(b)[0], tripSpan(b)[1], next, len(shared), Datestamp(shared[0]))
+				}
+			}
+		}
+	}
+}
+
+// endSeconds - seconds from the start of the service day to the last arrival of the trip
+func endSeconds(trip *gtfs.Trip) int {
+	return toSeconds(trip.StopTimes[len(trip.StopTimes)-1].Arrival_time)
+}
+
+// tripSpan - the first departure & last arrival of the trip as GTFS times
+func tripSpan(trip *gtfs.Trip) [2]string {
+	return [2]string{GTFSTime(trip.StopTimes[0].Departure_time), GTFSTime(trip.StopTimes[len(trip.StopTimes)-1].Arrival_time)}
+}
+
+// reportAgency - the agency the reports covering the whole feed are named after
+func (s *Scheduler) reportAgency() *gtfs.Agency {
+	if agencies := s.agencies(); len(agencies) > 0 {
+		return agencies[0]
+	}
+	return &gtfs.Agency{Name: "Feed"}
+}
+
+// PrintValidationCSV - writes the problems as CSV, one per row
+func (s *Scheduler) PrintValidationCSV(filename string, validation *Validation) error {
+	file, err := s.createFile(s.reportAgency(), filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"Severity", "Category", "Check", "File", "ID", "Message"})
+	for _, p := range validation.Problems {
+		writer.Write([]string{p.Severity, p.Category, p.Check, p.File, p.ID, p.Message})
+	}
+	writer.Flush()
+	log.Printf("%s: %d problems written\n", filename, len(validation.Problems))
+	return writer.Error()
+}
+
+// PrintValidationJSON - writes the validation as JSON
+func (s *Scheduler) PrintValidationJSON(filename string, validation *Validation) error {
+	data, err := json.MarshalIndent(validation, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := s.createFile(s.reportAgency(), filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(data)
+	log.Printf("%s: %d problems written\n", filename, len(validation.Problems))
+	return err
+}
+
+// PrintValidationHTML - writes the problems as a static HTML page
+func (s *Scheduler) PrintValidationHTML(filename string, validation *Validation) error {
+	agency := s.reportAgency()
+	feedInfo := &gtfs.FeedInfo{}
+	if len(s.Feed.FeedInfos) > 0 {
+		feedInfo = s.Feed.FeedInfos[0]
+	}
+	page := s.newPage(feedInfo, agency, "Feed validation")
+	page.Subtitle = validation.Feed
+	page.Period = fmt.Sprintf("%d errors, %d warnings", validation.Errors, validation.Warnings)
+	page.Problems = validation.Problems
+	return s.executeTemplate(agency, filename, "validation.html", page)
+}
@@ -0,0 +1,50 @@
+package timetable
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidateStopSequenceOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		stopTimes string
+		want      string // message of the stop_sequence_order problem of T1, none if empty
+	}{
+		{"in order", "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,08:00:00,08:00:00,S1,1\nT1,08:10:00,08:10:00,S2,2\nT1,08:20:00,08:20:00,S3,3\n" +
+			"T2,09:00:00,09:00:00,S1,1\nT2,09:10:00,09:10:00,S2,2\n", ""},
+		{"rows swapped", "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,08:10:00,08:10:00,S2,2\nT1,08:00:00,08:00:00,S1,1\nT1,08:20:00,08:20:00,S3,3\n" +
+			"T2,09:00:00,09:00:00,S1,1\nT2,09:10:00,09:10:00,S2,2\n", "row 3 has stop_sequence 1 after stop_sequence 2"},
+		{"trips interleaved", "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,08:00:00,08:00:00,S1,1\nT2,09:00:00,09:00:00,S1,1\nT1,08:10:00,08:10:00,S2,2\n" +
+			"T2,09:10:00,09:10:00,S2,2\nT1,08:20:00,08:20:00,S3,3\n", ""},
+		{"last rows swapped", "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,08:00:00,08:00:00,S1,1\nT1,08:20:00,08:20:00,S3,3\nT1,08:10:00,08:10:00,S2,2\n" +
+			"T2,09:00:00,09:00:00,S1,1\nT2,09:10:00,09:10:00,S2,2\n", "row 4 has stop_sequence 2 after stop_sequence 3"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeFeed(t, map[string]string{"stop_times.txt": test.stopTimes})
+			defer os.RemoveAll(dir)
+			s, err := Load(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var messages []string
+			for _, problem := range s.Validate("test").Problems {
+				if problem.Check == "stop_sequence_order" && problem.ID == "T1" && strings.HasPrefix(problem.Message, "row") {
+					messages = append(messages, problem.Message)
+				}
+			}
+			switch {
+			case test.want == "" && len(messages) != 0:
+				t.Errorf("got %q, want no problem", messages)
+			case test.want != "" && (len(messages) != 1 || messages[0] != test.want):
+				t.Errorf("got %q, want %q", messages, test.want)
+			}
+		})
+	}
+}